@@ -0,0 +1,259 @@
+// Package broadcast delivers every published value to all subscribers,
+// each with its own buffer and slow consumer policy.
+//
+// Example:
+//
+//	b := broadcast.New[Event](broadcast.Config{Buffer: 16, Policy: broadcast.Drop})
+//	sub, _ := b.Subscribe()
+//	go func() {
+//		defer sub.Unsubscribe()
+//		for ev := range sub.C() {
+//			handle(ev)
+//		}
+//	}()
+//
+//	b.Publish(ctx, ev)
+//	...
+//	b.Close() // every sub.C() is closed after its buffered values
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrClosed is returned by Publish and Subscribe after Close, and by
+	// Subscription.Err once the broadcaster was closed.
+	ErrClosed = errors.New("broadcast: closed")
+	// ErrSlowSubscriber is returned by Subscription.Err after the subscriber
+	// was disconnected by the Disconnect policy.
+	ErrSlowSubscriber = errors.New("broadcast: subscriber too slow")
+)
+
+// Policy decides what Publish does when a subscriber's buffer is full.
+type Policy int
+
+const (
+	// Block waits until the subscriber takes the value, so a slow subscriber
+	// slows down the publisher and all other subscribers.
+	Block Policy = iota
+	// Drop skips the value for this subscriber, see Subscription.Dropped.
+	Drop
+	// Disconnect unsubscribes the subscriber: its channel is closed after
+	// the values already buffered and Err returns ErrSlowSubscriber.
+	Disconnect
+)
+
+// Config holds the default subscriber settings.
+type Config struct {
+	// Buffer is the channel capacity of every subscriber.
+	Buffer int
+	// Policy is the slow subscriber policy, Block by default.
+	Policy Policy
+}
+
+// Broadcaster is a one-to-many channel. All methods are safe for concurrent
+// use.
+type Broadcaster[T any] struct {
+	cfg  Config
+	done chan struct{} // closed by Close, wakes blocked publishers
+
+	pubMu sync.Mutex // keeps the publish order the same for all subscribers
+
+	mu     sync.Mutex
+	subs   map[*Subscription[T]]struct{}
+	closed bool
+	once   sync.Once
+}
+
+// New creates a broadcaster without subscribers.
+func New[T any](cfg Config) *Broadcaster[T] {
+	if cfg.Buffer < 0 {
+		cfg.Buffer = 0
+	}
+	return &Broadcaster[T]{
+		cfg:  cfg,
+		done: make(chan struct{}),
+		subs: map[*Subscription[T]]struct{}{},
+	}
+}
+
+// Subscribe adds a subscriber with the default settings. It receives only
+// values published after Subscribe returns.
+func (b *Broadcaster[T]) Subscribe() (*Subscription[T], error) {
+	return b.SubscribeWith(b.cfg)
+}
+
+// SubscribeWith adds a subscriber with its own buffer and policy.
+func (b *Broadcaster[T]) SubscribeWith(cfg Config) (*Subscription[T], error) {
+	if cfg.Buffer < 0 {
+		cfg.Buffer = 0
+	}
+	s := &Subscription[T]{
+		b:       b,
+		policy:  cfg.Policy,
+		ch:      make(chan T, cfg.Buffer),
+		closing: make(chan struct{}),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	b.subs[s] = struct{}{}
+	return s, nil
+}
+
+// Len returns the number of subscribers.
+func (b *Broadcaster[T]) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Publish delivers v to every subscriber according to its policy. It
+// returns ctx.Err() when ctx is done while waiting for a Block subscriber;
+// v may then have reached only some of them.
+func (b *Broadcaster[T]) Publish(ctx context.Context, v T) error {
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	subs := make([]*Subscription[T], 0, len(b.subs))
+	for s := range b.subs {
+		subs = append(subs, s)
+	}
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		if err := s.send(ctx, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close unsubscribes everybody and rejects further Publish calls. Blocked
+// publishers return ErrClosed. Subscribers can still read the values in
+// their buffers, then their channels are closed. Close does not wait for the
+// subscribers.
+func (b *Broadcaster[T]) Close() {
+	b.once.Do(func() {
+		close(b.done)
+		b.mu.Lock()
+		b.closed = true
+		b.mu.Unlock()
+		// wait for a running Publish, it holds no subscriber after this
+		b.pubMu.Lock()
+		defer b.pubMu.Unlock()
+
+		b.mu.Lock()
+		subs := b.subs
+		b.subs = map[*Subscription[T]]struct{}{}
+		b.mu.Unlock()
+		for s := range subs {
+			s.close(ErrClosed)
+		}
+	})
+}
+
+// Subscription is a subscriber of a Broadcaster.
+type Subscription[T any] struct {
+	b       *Broadcaster[T]
+	policy  Policy
+	ch      chan T
+	closing chan struct{} // closed first on unsubscribe, wakes a blocked send
+
+	mu      sync.RWMutex // send holds it for reading, close for writing
+	closed  bool
+	once    sync.Once
+	err     error
+	dropped uint64
+}
+
+// C returns the channel of the published values. It is closed after
+// Unsubscribe, Close or a disconnect.
+func (s *Subscription[T]) C() <-chan T {
+	return s.ch
+}
+
+// Unsubscribe stops the delivery and closes C. Values already buffered can
+// still be read. It is safe to call it several times.
+func (s *Subscription[T]) Unsubscribe() {
+	s.b.mu.Lock()
+	delete(s.b.subs, s)
+	s.b.mu.Unlock()
+	s.close(nil)
+}
+
+// Err returns why C was closed: nil after Unsubscribe or while subscribed,
+// ErrClosed after the broadcaster's Close, ErrSlowSubscriber after a
+// disconnect.
+func (s *Subscription[T]) Err() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.err
+}
+
+// Dropped returns the number of values skipped by the Drop policy.
+func (s *Subscription[T]) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// send delivers v according to the policy. Only a context error is
+// returned, an unsubscribed subscriber is skipped.
+func (s *Subscription[T]) send(ctx context.Context, v T) error {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return nil
+	}
+	select {
+	case s.ch <- v:
+		s.mu.RUnlock()
+		return nil
+	default:
+	}
+	switch s.policy {
+	case Drop:
+		atomic.AddUint64(&s.dropped, 1)
+		s.mu.RUnlock()
+		return nil
+	case Disconnect:
+		s.mu.RUnlock()
+		s.b.mu.Lock()
+		delete(s.b.subs, s)
+		s.b.mu.Unlock()
+		s.close(ErrSlowSubscriber)
+		return nil
+	}
+	defer s.mu.RUnlock()
+	select {
+	case s.ch <- v:
+		return nil
+	case <-s.closing:
+		return nil
+	case <-s.b.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Subscription[T]) close(err error) {
+	s.once.Do(func() {
+		close(s.closing)
+		s.mu.Lock()
+		s.closed = true
+		s.err = err
+		close(s.ch)
+		s.mu.Unlock()
+	})
+}
@@ -0,0 +1,186 @@
+package broadcast_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/broadcast"
+	"github.com/r3code/go-useful-snippets/channels/internal/leaktest"
+)
+
+func drain(sub *broadcast.Subscription[int]) []int {
+	var got []int
+	for v := range sub.C() {
+		got = append(got, v)
+	}
+	return got
+}
+
+func TestAllSubscribersGetAllValues(t *testing.T) {
+	defer leaktest.Check(t)()
+	b := broadcast.New[int](broadcast.Config{Buffer: 1})
+	var wg sync.WaitGroup
+	results := make([][]int, 3)
+	for i := range results {
+		sub, err := b.Subscribe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = drain(sub)
+		}(i)
+	}
+	for v := 0; v < 100; v++ {
+		if err := b.Publish(context.Background(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Close()
+	wg.Wait()
+	for i, got := range results {
+		if len(got) != 100 {
+			t.Fatalf("subscriber %d got %d values, want 100", i, len(got))
+		}
+		for v := range got {
+			if got[v] != v {
+				t.Fatalf("subscriber %d: value %d at %d, want publish order", i, got[v], v)
+			}
+		}
+	}
+	if err := b.Publish(context.Background(), 1); !errors.Is(err, broadcast.ErrClosed) {
+		t.Errorf("Publish after Close = %v, want ErrClosed", err)
+	}
+	if _, err := b.Subscribe(); !errors.Is(err, broadcast.ErrClosed) {
+		t.Errorf("Subscribe after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	b := broadcast.New[int](broadcast.Config{})
+	slow, _ := b.SubscribeWith(broadcast.Config{Buffer: 2, Policy: broadcast.Drop})
+	for v := 0; v < 5; v++ {
+		if err := b.Publish(context.Background(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := slow.Dropped(); n != 3 {
+		t.Errorf("Dropped() = %d, want 3", n)
+	}
+	b.Close()
+	if got := drain(slow); len(got) != 2 || got[0] != 0 || got[1] != 1 {
+		t.Errorf("got %v, want the first two values", got)
+	}
+	if err := slow.Err(); !errors.Is(err, broadcast.ErrClosed) {
+		t.Errorf("Err() = %v, want ErrClosed", err)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	b := broadcast.New[int](broadcast.Config{Buffer: 1, Policy: broadcast.Disconnect})
+	slow, _ := b.Subscribe()
+	fast, _ := b.SubscribeWith(broadcast.Config{Buffer: 10})
+	for v := 0; v < 3; v++ {
+		if err := b.Publish(context.Background(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := drain(slow); len(got) != 1 {
+		t.Errorf("slow subscriber got %v, want only the buffered value", got)
+	}
+	if err := slow.Err(); !errors.Is(err, broadcast.ErrSlowSubscriber) {
+		t.Errorf("Err() = %v, want ErrSlowSubscriber", err)
+	}
+	if n := b.Len(); n != 1 {
+		t.Errorf("Len() = %d, want 1", n)
+	}
+	b.Close()
+	if got := drain(fast); len(got) != 3 {
+		t.Errorf("fast subscriber got %v, want all values", got)
+	}
+}
+
+func TestBlock_ContextAndClose(t *testing.T) {
+	defer leaktest.Check(t)()
+	b := broadcast.New[int](broadcast.Config{})
+	sub, _ := b.Subscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.Publish(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Publish() = %v, want DeadlineExceeded", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- b.Publish(context.Background(), 2) }()
+	time.Sleep(10 * time.Millisecond)
+	b.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, broadcast.ErrClosed) {
+			t.Errorf("blocked Publish() = %v, want ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not release the blocked publisher")
+	}
+	if _, ok := <-sub.C(); ok {
+		t.Error("want a closed channel after Close")
+	}
+}
+
+func TestUnsubscribeReleasesPublisher(t *testing.T) {
+	defer leaktest.Check(t)()
+	b := broadcast.New[int](broadcast.Config{})
+	defer b.Close()
+	sub, _ := b.Subscribe()
+
+	done := make(chan error)
+	go func() { done <- b.Publish(context.Background(), 1) }()
+	time.Sleep(10 * time.Millisecond)
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Publish() = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Unsubscribe did not release the blocked publisher")
+	}
+	if err := sub.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil after Unsubscribe", err)
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	defer leaktest.Check(t)()
+	b := broadcast.New[int](broadcast.Config{Buffer: 4, Policy: broadcast.Drop})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for v := 0; v < 200; v++ {
+				b.Publish(context.Background(), v)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				sub, err := b.Subscribe()
+				if err != nil {
+					return
+				}
+				<-sub.C()
+				sub.Unsubscribe()
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	b.Close()
+	wg.Wait()
+}
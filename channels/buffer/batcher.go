@@ -0,0 +1,154 @@
+package buffer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BatcherConfig holds the Batcher settings.
+type BatcherConfig[T any] struct {
+	// Size is the batch size that triggers a flush, 100 by default.
+	Size int
+	// Interval is the longest time a value waits for its batch to fill up,
+	// counted from the first value of the batch; 0 means no time limit.
+	Interval time.Duration
+	// Flush handles a batch, required. It is called from a single goroutine
+	// and Add waits while it runs. The slice is not reused by the Batcher.
+	Flush func(ctx context.Context, batch []T) error
+	// OnError is called with the batches whose Flush failed on size or
+	// interval; errors of explicit flushes are returned to the caller.
+	OnError func(batch []T, err error)
+}
+
+// Batcher collects values into batches handed to Flush.
+type Batcher[T any] struct {
+	cfg BatcherConfig[T]
+
+	ctx     context.Context // passed to Flush, cancelled by Close after its final flush
+	cancel  context.CancelFunc
+	in      chan T
+	flushes chan chan error
+	closing chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	err     error // of the final flush
+}
+
+// NewBatcher starts the batcher goroutine. ctx is the parent of the context
+// passed to Flush.
+func NewBatcher[T any](ctx context.Context, cfg BatcherConfig[T]) *Batcher[T] {
+	if cfg.Size <= 0 {
+		cfg.Size = 100
+	}
+	b := &Batcher[T]{
+		cfg:     cfg,
+		in:      make(chan T),
+		flushes: make(chan chan error),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	b.ctx, b.cancel = context.WithCancel(ctx)
+	go b.loop()
+	return b
+}
+
+// Add puts v into the current batch, waiting while a batch is flushed.
+// Returns ErrClosed after Close or ctx.Err().
+func (b *Batcher[T]) Add(ctx context.Context, v T) error {
+	select {
+	case <-b.closing:
+		return ErrClosed
+	default:
+	}
+	select {
+	case b.in <- v:
+		return nil
+	case <-b.closing:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush flushes the current batch now and returns the Flush error.
+func (b *Batcher[T]) Flush(ctx context.Context) error {
+	reply := make(chan error, 1)
+	select {
+	case b.flushes <- reply:
+	case <-b.closing:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting values, flushes the last batch and waits for it
+// until ctx is done. It returns the error of the final flush or ctx.Err(),
+// in the latter case the context passed to Flush is cancelled.
+func (b *Batcher[T]) Close(ctx context.Context) error {
+	b.once.Do(func() { close(b.closing) })
+	select {
+	case <-b.done:
+		return b.err
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
+
+func (b *Batcher[T]) loop() {
+	defer close(b.done)
+	defer b.cancel()
+	var (
+		batch   []T
+		timer   *time.Timer
+		expired <-chan time.Time
+	)
+	flush := func() error {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		out := batch
+		batch = make([]T, 0, b.cfg.Size)
+		return b.cfg.Flush(b.ctx, out)
+	}
+	report := func(err error, out []T) {
+		if err != nil && b.cfg.OnError != nil {
+			b.cfg.OnError(out, err)
+		}
+	}
+	for {
+		select {
+		case v := <-b.in:
+			batch = append(batch, v)
+			if len(batch) >= b.cfg.Size {
+				out := batch
+				report(flush(), out)
+			} else if len(batch) == 1 && b.cfg.Interval > 0 {
+				timer = time.NewTimer(b.cfg.Interval)
+				expired = timer.C
+			}
+		case <-expired:
+			timer, expired = nil, nil
+			out := batch
+			report(flush(), out)
+		case reply := <-b.flushes:
+			reply <- flush()
+		case <-b.closing:
+			// values sent before Close are in; a racing Add returned ErrClosed
+			b.err = flush()
+			return
+		}
+	}
+}
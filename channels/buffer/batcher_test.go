@@ -0,0 +1,111 @@
+package buffer_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/buffer"
+	"github.com/r3code/go-useful-snippets/channels/internal/leaktest"
+)
+
+type flushed struct {
+	mu      sync.Mutex
+	batches [][]int
+	ch      chan []int
+}
+
+func newFlushed() *flushed {
+	return &flushed{ch: make(chan []int, 10)}
+}
+
+func (f *flushed) flush(_ context.Context, batch []int) error {
+	f.mu.Lock()
+	f.batches = append(f.batches, batch)
+	f.mu.Unlock()
+	f.ch <- batch
+	return nil
+}
+
+func TestBatcher_Size(t *testing.T) {
+	defer leaktest.Check(t)()
+	f := newFlushed()
+	b := buffer.NewBatcher(context.Background(), buffer.BatcherConfig[int]{Size: 3, Flush: f.flush})
+	for i := 0; i < 7; i++ {
+		if err := b.Add(context.Background(), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.batches) != 3 || len(f.batches[0]) != 3 || len(f.batches[1]) != 3 || len(f.batches[2]) != 1 {
+		t.Errorf("batches %v, want sizes 3, 3 and the rest on Close", f.batches)
+	}
+	if err := b.Add(context.Background(), 1); !errors.Is(err, buffer.ErrClosed) {
+		t.Errorf("Add after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestBatcher_Interval(t *testing.T) {
+	defer leaktest.Check(t)()
+	f := newFlushed()
+	b := buffer.NewBatcher(context.Background(), buffer.BatcherConfig[int]{
+		Size:     100,
+		Interval: 20 * time.Millisecond,
+		Flush:    f.flush,
+	})
+	defer b.Close(context.Background())
+	b.Add(context.Background(), 1)
+	b.Add(context.Background(), 2)
+	select {
+	case batch := <-f.ch:
+		if len(batch) != 2 {
+			t.Errorf("got %v, want [1 2]", batch)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the batch was not flushed after Interval")
+	}
+}
+
+func TestBatcher_FlushAndErrors(t *testing.T) {
+	defer leaktest.Check(t)()
+	boom := errors.New("boom")
+	var failed [][]int
+	b := buffer.NewBatcher(context.Background(), buffer.BatcherConfig[int]{
+		Size:    2,
+		Flush:   func(context.Context, []int) error { return boom },
+		OnError: func(batch []int, err error) { failed = append(failed, batch) },
+	})
+	b.Add(context.Background(), 1)
+	if err := b.Flush(context.Background()); !errors.Is(err, boom) {
+		t.Errorf("Flush() = %v, want boom", err)
+	}
+	b.Add(context.Background(), 2)
+	b.Add(context.Background(), 3)
+	b.Add(context.Background(), 4)
+	if err := b.Close(context.Background()); !errors.Is(err, boom) {
+		t.Errorf("Close() = %v, want the final flush error", err)
+	}
+	if len(failed) != 1 || len(failed[0]) != 2 {
+		t.Errorf("OnError got %v, want the size triggered batch", failed)
+	}
+}
+
+func TestBatcher_CloseTimeout(t *testing.T) {
+	defer leaktest.Check(t)()
+	b := buffer.NewBatcher(context.Background(), buffer.BatcherConfig[int]{
+		Flush: func(ctx context.Context, _ []int) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	b.Add(context.Background(), 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close() = %v, want DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,12 @@
+// Package buffer provides two alternatives to a fixed `make(chan Job, 100)`:
+//
+// Elastic is a channel whose buffer grows on demand, so producers do not
+// block while consumers catch up; a high-water mark reports bursts and an
+// optional length or memory cap bounds it.
+//
+// Batcher collects values and hands them over in batches, when a batch
+// reaches its size or after an interval, e.g. for bulk inserts.
+//
+// Both are closed explicitly: Close stops accepting values and delivers the
+// buffered ones, Elastic.Drain returns them to the caller instead.
+package buffer
@@ -0,0 +1,254 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrFull is returned by TrySend when the cap is reached.
+	ErrFull = errors.New("buffer: full")
+	// ErrClosed is returned after Close.
+	ErrClosed = errors.New("buffer: closed")
+)
+
+// ElasticConfig holds the Elastic settings. The zero value is an unbounded
+// buffer.
+type ElasticConfig[T any] struct {
+	// MaxLen caps the number of buffered values, 0 means no limit.
+	MaxLen int
+	// MaxBytes caps the memory of buffered values as measured by SizeOf,
+	// 0 means no limit. A single value larger than MaxBytes is accepted
+	// into an empty buffer.
+	MaxBytes int64
+	// SizeOf returns the size of a value in bytes, required with MaxBytes.
+	SizeOf func(v T) int64
+	// HighWater is the length reported to OnHighWater.
+	HighWater int
+	// OnHighWater is called when the buffer grows to HighWater values,
+	// again only after it shrank below HighWater/2. It must not block.
+	OnHighWater func(n int)
+}
+
+// Elastic is a FIFO channel with a growing buffer: Send blocks only at
+// MaxLen or MaxBytes, and the values are read from Out.
+type Elastic[T any] struct {
+	cfg  ElasticConfig[T]
+	out  chan T
+	done chan struct{} // closed when the pump exits
+	stop chan struct{} // closed by Drain
+
+	mu      sync.Mutex
+	items   []T
+	head    int
+	bytes   int64
+	peak    int
+	alarmed bool
+	closed  bool
+	changed chan struct{} // closed and replaced on every change
+	once    sync.Once
+}
+
+// ElasticStats is a snapshot of an Elastic buffer.
+type ElasticStats struct {
+	// Len is the number of buffered values.
+	Len int
+	// Bytes is their size when SizeOf is set.
+	Bytes int64
+	// Peak is the largest Len so far, the high-water mark.
+	Peak int
+}
+
+// NewElastic creates the buffer and starts the goroutine feeding Out, it
+// exits when Out is closed.
+func NewElastic[T any](cfg ElasticConfig[T]) *Elastic[T] {
+	e := &Elastic[T]{
+		cfg:     cfg,
+		out:     make(chan T),
+		done:    make(chan struct{}),
+		stop:    make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+	go e.pump()
+	return e
+}
+
+// Out returns the channel of buffered values. It is closed after Close once
+// all values are read, or at once after Drain.
+func (e *Elastic[T]) Out() <-chan T {
+	return e.out
+}
+
+// Send buffers v, waiting while the buffer is at its cap. Returns
+// ErrClosed after Close or ctx.Err().
+func (e *Elastic[T]) Send(ctx context.Context, v T) error {
+	for {
+		e.mu.Lock()
+		ok, err := e.put(v)
+		changed := e.changed
+		e.mu.Unlock()
+		if ok || err != nil {
+			return err
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TrySend buffers v without waiting, returns ErrFull at the cap.
+func (e *Elastic[T]) TrySend(v T) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ok, err := e.put(v)
+	if err == nil && !ok {
+		return ErrFull
+	}
+	return err
+}
+
+// Len returns the number of buffered values.
+func (e *Elastic[T]) Len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.items) - e.head
+}
+
+// Stats returns the buffer state.
+func (e *Elastic[T]) Stats() ElasticStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return ElasticStats{Len: len(e.items) - e.head, Bytes: e.bytes, Peak: e.peak}
+}
+
+// Close stops accepting values. Out delivers the buffered ones and is
+// closed after the last of them.
+func (e *Elastic[T]) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.closed {
+		e.closed = true
+		e.notify()
+	}
+}
+
+// Drain stops accepting values, closes Out without delivering the rest and
+// returns the values still buffered.
+func (e *Elastic[T]) Drain() []T {
+	e.Close()
+	e.once.Do(func() { close(e.stop) })
+	<-e.done
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	rest := append([]T(nil), e.items[e.head:]...)
+	e.items, e.head, e.bytes = nil, 0, 0
+	return rest
+}
+
+// put appends v unless the buffer is at its cap. mu must be held.
+func (e *Elastic[T]) put(v T) (ok bool, err error) {
+	if e.closed {
+		return false, ErrClosed
+	}
+	n := len(e.items) - e.head
+	if e.cfg.MaxLen > 0 && n >= e.cfg.MaxLen {
+		return false, nil
+	}
+	var size int64
+	if e.cfg.MaxBytes > 0 && e.cfg.SizeOf != nil {
+		size = e.cfg.SizeOf(v)
+		if n > 0 && e.bytes+size > e.cfg.MaxBytes {
+			return false, nil
+		}
+	}
+	e.items = append(e.items, v)
+	e.bytes += size
+	n++
+	if n > e.peak {
+		e.peak = n
+	}
+	if e.cfg.HighWater > 0 && n >= e.cfg.HighWater && !e.alarmed {
+		e.alarmed = true
+		if e.cfg.OnHighWater != nil {
+			e.cfg.OnHighWater(n)
+		}
+	}
+	e.notify()
+	return true, nil
+}
+
+// pump moves values from the buffer to Out.
+func (e *Elastic[T]) pump() {
+	defer close(e.done)
+	defer close(e.out)
+	for {
+		e.mu.Lock()
+		if e.head == len(e.items) {
+			closed := e.closed
+			changed := e.changed
+			e.mu.Unlock()
+			if closed {
+				return
+			}
+			select {
+			case <-changed:
+				continue
+			case <-e.stop:
+				return
+			}
+		}
+		v := e.items[e.head]
+		e.mu.Unlock()
+
+		select {
+		case e.out <- v:
+		case <-e.stop:
+			return
+		}
+
+		e.mu.Lock()
+		e.pop()
+		e.mu.Unlock()
+	}
+}
+
+// pop removes the delivered head and shrinks the storage. mu must be held.
+func (e *Elastic[T]) pop() {
+	if e.cfg.SizeOf != nil && e.cfg.MaxBytes > 0 {
+		e.bytes -= e.cfg.SizeOf(e.items[e.head])
+	}
+	var zero T
+	e.items[e.head] = zero
+	e.head++
+	n := len(e.items) - e.head
+	switch {
+	case n == 0:
+		// reuse the storage unless it grew much during a burst
+		if cap(e.items) > 1024 {
+			e.items = nil
+		} else {
+			e.items = e.items[:0]
+		}
+		e.head = 0
+	case e.head > n:
+		// more dead slots than live values: compact
+		old := len(e.items)
+		e.items = append(e.items[:0], e.items[e.head:]...)
+		clear(e.items[len(e.items):old])
+		e.head = 0
+	}
+	if e.alarmed && n < e.cfg.HighWater/2 {
+		e.alarmed = false
+	}
+	e.notify()
+}
+
+// notify wakes up everybody waiting for a change. mu must be held.
+func (e *Elastic[T]) notify() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
@@ -0,0 +1,109 @@
+package buffer_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/buffer"
+	"github.com/r3code/go-useful-snippets/channels/internal/leaktest"
+)
+
+func TestElastic_GrowsAndDrainsOnClose(t *testing.T) {
+	defer leaktest.Check(t)()
+	var alarms []int
+	e := buffer.NewElastic(buffer.ElasticConfig[int]{
+		HighWater:   500,
+		OnHighWater: func(n int) { alarms = append(alarms, n) },
+	})
+	// nobody reads yet, the producer must not block
+	for i := 0; i < 1000; i++ {
+		if err := e.TrySend(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.Close()
+	if err := e.TrySend(1); !errors.Is(err, buffer.ErrClosed) {
+		t.Errorf("TrySend after Close = %v, want ErrClosed", err)
+	}
+	n := 0
+	for v := range e.Out() {
+		if v != n {
+			t.Fatalf("got %d, want %d", v, n)
+		}
+		n++
+	}
+	if n != 1000 {
+		t.Errorf("read %d values, want 1000", n)
+	}
+	if st := e.Stats(); st.Len != 0 || st.Peak != 1000 {
+		t.Errorf("Stats() = %+v, want empty with peak 1000", st)
+	}
+	if len(alarms) != 1 || alarms[0] != 500 {
+		t.Errorf("high-water alarms %v, want [500]", alarms)
+	}
+}
+
+func TestElastic_MaxLen(t *testing.T) {
+	defer leaktest.Check(t)()
+	e := buffer.NewElastic(buffer.ElasticConfig[int]{MaxLen: 2})
+	defer e.Drain()
+	e.TrySend(1)
+	e.TrySend(2)
+	if err := e.TrySend(3); !errors.Is(err, buffer.ErrFull) {
+		t.Errorf("TrySend at MaxLen = %v, want ErrFull", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := e.Send(ctx, 3); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Send at MaxLen = %v, want DeadlineExceeded", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- e.Send(context.Background(), 3) }()
+	if v := <-e.Out(); v != 1 {
+		t.Errorf("got %d, want 1", v)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Send after a read = %v", err)
+	}
+}
+
+func TestElastic_MaxBytes(t *testing.T) {
+	defer leaktest.Check(t)()
+	e := buffer.NewElastic(buffer.ElasticConfig[string]{
+		MaxBytes: 10,
+		SizeOf:   func(s string) int64 { return int64(len(s)) },
+	})
+	defer e.Drain()
+	if err := e.TrySend("0123456789abc"); err != nil {
+		t.Errorf("a large value into an empty buffer: %v", err)
+	}
+	if err := e.TrySend("x"); !errors.Is(err, buffer.ErrFull) {
+		t.Errorf("TrySend over MaxBytes = %v, want ErrFull", err)
+	}
+	<-e.Out()
+	if err := e.TrySend("01234"); err != nil {
+		t.Error(err)
+	}
+	if st := e.Stats(); st.Bytes != 5 {
+		t.Errorf("Bytes = %d, want 5", st.Bytes)
+	}
+}
+
+func TestElastic_Drain(t *testing.T) {
+	defer leaktest.Check(t)()
+	e := buffer.NewElastic(buffer.ElasticConfig[int]{})
+	for i := 0; i < 5; i++ {
+		e.TrySend(i)
+	}
+	<-e.Out()
+	rest := e.Drain()
+	if len(rest) != 4 || rest[0] != 1 {
+		t.Errorf("Drain() = %v, want [1 2 3 4]", rest)
+	}
+	if _, ok := <-e.Out(); ok {
+		t.Error("want Out closed after Drain")
+	}
+}
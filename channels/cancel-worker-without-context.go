@@ -1,3 +1,5 @@
+//go:build ignore
+
 // create a cancel channel
 cancelChan := make(chan struct{})
 
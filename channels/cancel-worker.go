@@ -1,3 +1,5 @@
+//go:build ignore
+
 // create a context that can be cancelled
 ctx, cancel := context.WithCancel(context.Background())
 
@@ -18,6 +20,8 @@ func worker(ctx context.Context, jobChan <-chan Job) {
 
 // Invoke cancel when the worker needs to be stopped. This *does not* wait
 // for the worker to exit.
+// To wait for the workers and get back the jobs they did not process use
+// Shutdown(ctx, pool.Drain) or Shutdown(ctx, pool.Abort) of channels/pool.
 cancel()
 
 
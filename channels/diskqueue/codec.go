@@ -0,0 +1,24 @@
+package diskqueue
+
+import "encoding/json"
+
+// Codec converts message values to bytes stored on disk.
+type Codec[T any] interface {
+	Marshal(v T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// JSONCodec is the default Codec.
+type JSONCodec[T any] struct{}
+
+// Marshal implements Codec.
+func (JSONCodec[T]) Marshal(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements Codec.
+func (JSONCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
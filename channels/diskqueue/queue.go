@@ -0,0 +1,603 @@
+// Package diskqueue is a persistent job queue: messages are stored in
+// append-only segment files and survive a process restart. Delivery is
+// at-least-once: a dequeued message stays on disk until it is acked and is
+// delivered again if the process dies before that.
+//
+// The index of message positions is kept in memory only: Open rebuilds it
+// by scanning the segment files. Fully acked segments are removed, so the
+// scan covers mostly messages not acked yet. A message that cannot be read or
+// decoded is not delivered: Dequeue returns an error naming it and moves
+// its payload to the quarantine subdirectory of Config.Dir.
+//
+// Queue implements queue.Queue and queue.Acker for *Message[T], so it can
+// replace the in-memory queue of the worker pool:
+//
+//	q, err := diskqueue.Open(diskqueue.Config[Job]{Dir: "/var/lib/app/jobs"})
+//	...
+//	dead, err := pool.NewDeadLetterFile[*diskqueue.Message[Job]]("/var/lib/app/jobs.dead", true)
+//	...
+//	p := pool.New(ctx, func(ctx context.Context, m *diskqueue.Message[Job]) error {
+//		return process(ctx, m.Value) // acked once it succeeded or finally failed
+//	}, pool.Config[*diskqueue.Message[Job]]{Workers: 4, Queue: q, DeadLetter: dead})
+//	err = p.Submit(ctx, diskqueue.NewMessage(job))
+//	...
+//	p.Stop()
+//	p.Wait()
+//	q.Shutdown()
+package diskqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/queue"
+)
+
+// SyncPolicy defines when written data is flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways calls fsync after every enqueue, ack and nack. Slow, but an
+	// accepted message survives a power loss.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval calls fsync every Config.SyncInterval. A crash of the
+	// process loses nothing, a power loss may lose the last interval.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+const (
+	defaultSegmentSize  = 64 << 20
+	defaultCapacity     = 10000
+	defaultSyncInterval = time.Second
+
+	// quarantineDir is the subdirectory of Config.Dir with the payloads of
+	// messages that could not be read or decoded.
+	quarantineDir = "quarantine"
+)
+
+// Config holds the queue settings.
+type Config[T any] struct {
+	// Dir is the directory for segment and state files, created if missing.
+	// Only one Queue may use a directory at a time.
+	Dir string
+	// SegmentSize is the size after which a new segment file is started, 64MB by default.
+	SegmentSize int64
+	// Capacity is the maximum number of messages not yet acked, 10000 by default.
+	Capacity int
+	// Sync is the fsync policy, SyncAlways by default.
+	Sync SyncPolicy
+	// SyncInterval is the fsync period for SyncInterval, one second by default.
+	SyncInterval time.Duration
+	// Codec encodes message values, JSONCodec by default.
+	Codec Codec[T]
+}
+
+// Message is a job stored in the queue.
+type Message[T any] struct {
+	// ID is assigned by the queue on Enqueue.
+	ID uint64
+	// Value is the job itself.
+	Value T
+	// Attempt is the delivery number, 1 for the first delivery; it grows
+	// with every Nack.
+	Attempt int
+}
+
+// NewMessage wraps a value to be enqueued.
+func NewMessage[T any](v T) *Message[T] {
+	return &Message[T]{Value: v}
+}
+
+// Queue is a disk backed queue.Queue with at-least-once delivery.
+type Queue[T any] struct {
+	cfg Config[T]
+
+	mu       sync.Mutex
+	segments []*segment // ascending, the last one is written to
+	state    *stateLog
+	ready    []*entry
+	inflight map[uint64]*entry
+	nextID   uint64
+	closed   bool
+	shutdown bool
+	dirty    bool
+	changed  chan struct{}
+
+	stopSync chan struct{}
+	syncDone chan struct{}
+}
+
+var (
+	_ queue.Queue[*Message[int]] = (*Queue[int])(nil)
+	_ queue.Acker[*Message[int]] = (*Queue[int])(nil)
+	_ queue.Timed[*Message[int]] = (*Queue[int])(nil)
+)
+
+// Open opens or creates the queue in cfg.Dir and recovers its content:
+// messages that were not acked before the last shutdown or crash are ready
+// to be dequeued again, with Attempt restored from the nacks.
+func Open[T any](cfg Config[T]) (*Queue[T], error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("diskqueue: Dir is required")
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = defaultSegmentSize
+	}
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = defaultCapacity
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = defaultSyncInterval
+	}
+	if cfg.Codec == nil {
+		cfg.Codec = JSONCodec[T]{}
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("diskqueue: %w", err)
+	}
+	q := &Queue[T]{
+		cfg:      cfg,
+		inflight: map[uint64]*entry{},
+		nextID:   1,
+		changed:  make(chan struct{}),
+	}
+	if err := q.recover(); err != nil {
+		q.closeFiles()
+		return nil, fmt.Errorf("diskqueue: recover %s: %w", cfg.Dir, err)
+	}
+	if cfg.Sync == SyncInterval {
+		q.stopSync = make(chan struct{})
+		q.syncDone = make(chan struct{})
+		go q.syncLoop()
+	}
+	return q, nil
+}
+
+func (q *Queue[T]) recover() error {
+	var err error
+	if q.state, err = openState(q.cfg.Dir); err != nil {
+		return err
+	}
+	acked, nacks, err := q.state.load()
+	if err != nil {
+		return err
+	}
+	firsts, err := listSegments(q.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	live := map[uint64]int{}
+	for _, first := range firsts {
+		seg, err := openSegment(q.cfg.Dir, first)
+		if err != nil {
+			return err
+		}
+		q.segments = append(q.segments, seg)
+		if first > q.nextID {
+			q.nextID = first
+		}
+		err = seg.scan(func(id uint64, off int64, size int) {
+			if id >= q.nextID {
+				q.nextID = id + 1
+			}
+			if acked[id] {
+				seg.acked = append(seg.acked, id)
+				return
+			}
+			q.ready = append(q.ready, &entry{id: id, seg: seg, off: off, size: size, attempt: nacks[id], ready: time.Now()})
+			seg.live++
+			if n := nacks[id]; n > 0 {
+				live[id] = n
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// drop fully acked segments, keep the last one: its name holds nextID
+	kept := q.segments[:0]
+	for i, seg := range q.segments {
+		if seg.live == 0 && i < len(q.segments)-1 {
+			if err := seg.remove(); err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	q.segments = kept
+	if len(q.segments) == 0 {
+		seg, err := openSegment(q.cfg.Dir, q.nextID)
+		if err != nil {
+			return err
+		}
+		q.segments = append(q.segments, seg)
+	}
+	return q.rewriteState(live)
+}
+
+// Enqueue implements queue.Queue, blocking while Capacity messages are not acked.
+// It assigns m.ID; the message is on disk when Enqueue returns.
+func (q *Queue[T]) Enqueue(ctx context.Context, m *Message[T]) error {
+	data, err := q.cfg.Codec.Marshal(m.Value)
+	if err != nil {
+		return fmt.Errorf("diskqueue: encode message: %w", err)
+	}
+	for {
+		q.mu.Lock()
+		ok, err := q.put(m, data)
+		changed := q.changed
+		q.mu.Unlock()
+		if ok || err != nil {
+			return err
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TryEnqueue implements queue.Queue, returns queue.ErrFull at Capacity.
+func (q *Queue[T]) TryEnqueue(m *Message[T]) error {
+	data, err := q.cfg.Codec.Marshal(m.Value)
+	if err != nil {
+		return fmt.Errorf("diskqueue: encode message: %w", err)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ok, err := q.put(m, data)
+	if err == nil && !ok {
+		return queue.ErrFull
+	}
+	return err
+}
+
+// Dequeue implements queue.Queue. The returned message must be acked or nacked.
+func (q *Queue[T]) Dequeue(ctx context.Context) (*Message[T], error) {
+	m, _, err := q.DequeueTimed(ctx)
+	return m, err
+}
+
+// DequeueTimed implements queue.Timed. The enqueue time is when the message
+// became ready: its Enqueue, the last Nack, or Open for recovered ones.
+func (q *Queue[T]) DequeueTimed(ctx context.Context) (*Message[T], time.Time, error) {
+	for {
+		q.mu.Lock()
+		if q.shutdown || (q.closed && len(q.ready) == 0) {
+			q.mu.Unlock()
+			return nil, time.Time{}, queue.ErrClosed
+		}
+		if len(q.ready) > 0 {
+			ready := q.ready[0].ready
+			m, err := q.take()
+			q.mu.Unlock()
+			return m, ready, err
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, time.Time{}, ctx.Err()
+		}
+	}
+}
+
+// Ack implements queue.Acker: the message is done and will not be delivered again.
+func (q *Queue[T]) Ack(m *Message[T]) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, err := q.settle(m, opAck)
+	if err != nil {
+		return err
+	}
+	e.seg.live--
+	e.seg.acked = append(e.seg.acked, e.id)
+	if err := q.dropSegments(); err != nil {
+		return fmt.Errorf("diskqueue: remove segment: %w", err)
+	}
+	q.notify()
+	return nil
+}
+
+// Nack implements queue.Acker: the message goes to the end of the queue and
+// is delivered again with Attempt increased.
+func (q *Queue[T]) Nack(m *Message[T]) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, err := q.settle(m, opNack)
+	if err != nil {
+		return err
+	}
+	e.attempt++
+	e.ready = time.Now()
+	q.ready = append(q.ready, e)
+	q.notify()
+	return nil
+}
+
+// Len implements queue.Queue: the number of messages ready for delivery.
+func (q *Queue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.ready)
+}
+
+// InFlight returns the number of dequeued messages not acked or nacked yet.
+func (q *Queue[T]) InFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.inflight)
+}
+
+// Cap implements queue.Queue.
+func (q *Queue[T]) Cap() int {
+	return q.cfg.Capacity
+}
+
+// Stats implements queue.StatsProvider, Len counts ready and in-flight messages
+// since both occupy capacity.
+func (q *Queue[T]) Stats() queue.Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return queue.Stats{Len: len(q.ready) + len(q.inflight), Cap: q.cfg.Capacity}
+}
+
+// Close implements queue.Queue: new messages are rejected, the stored ones
+// can still be dequeued and acked. Call Shutdown to release the files.
+func (q *Queue[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.notify()
+	}
+}
+
+// Shutdown closes the queue, syncs and closes the files. Messages not acked
+// yet stay on disk and are delivered again by the next Open.
+func (q *Queue[T]) Shutdown() error {
+	q.Close()
+	if q.stopSync != nil {
+		close(q.stopSync)
+		<-q.syncDone
+		q.stopSync = nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.shutdown {
+		return nil
+	}
+	q.shutdown = true
+	q.notify()
+	err := q.sync()
+	if cerr := q.closeFiles(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// put writes the message, ok is false at capacity. mu must be held.
+func (q *Queue[T]) put(m *Message[T], data []byte) (ok bool, err error) {
+	if q.closed {
+		return false, queue.ErrClosed
+	}
+	if len(q.ready)+len(q.inflight) >= q.cfg.Capacity {
+		return false, nil
+	}
+	seg := q.segments[len(q.segments)-1]
+	if seg.size > 0 && seg.size+headerSize+int64(len(data)) > q.cfg.SegmentSize {
+		if seg, err = openSegment(q.cfg.Dir, q.nextID); err != nil {
+			return false, fmt.Errorf("diskqueue: new segment: %w", err)
+		}
+		q.segments = append(q.segments, seg)
+		if err := q.dropSegments(); err != nil {
+			return false, fmt.Errorf("diskqueue: remove segment: %w", err)
+		}
+	}
+	id := q.nextID
+	off, err := seg.append(id, data)
+	if err != nil {
+		return false, fmt.Errorf("diskqueue: write message: %w", err)
+	}
+	if err := q.written(seg.file); err != nil {
+		return false, err
+	}
+	q.nextID++
+	seg.live++
+	m.ID = id
+	q.ready = append(q.ready, &entry{id: id, seg: seg, off: off, size: len(data), ready: time.Now()})
+	q.notify()
+	return true, nil
+}
+
+// take reads the next ready message. A message that cannot be read or
+// decoded is quarantined rather than delivered again and again; the error
+// names it. mu must be held.
+func (q *Queue[T]) take() (*Message[T], error) {
+	e := q.ready[0]
+	q.ready[0] = nil
+	q.ready = q.ready[1:]
+	data, err := e.seg.read(e.off, e.size)
+	if err == nil {
+		var v T
+		if v, err = q.cfg.Codec.Unmarshal(data); err == nil {
+			q.inflight[e.id] = e
+			return &Message[T]{ID: e.id, Value: v, Attempt: e.attempt + 1}, nil
+		}
+	}
+	if qerr := q.quarantine(e, data); qerr != nil {
+		// skipped until the next Open
+		return nil, fmt.Errorf("diskqueue: read message %d: %w (quarantine failed: %v)", e.id, err, qerr)
+	}
+	return nil, fmt.Errorf("diskqueue: read message %d, moved to %s: %w", e.id, quarantineDir, err)
+}
+
+// quarantine saves the payload of an undeliverable message to the
+// quarantine directory, e.g. to replay it after a codec fix, and acks the
+// message. mu must be held.
+func (q *Queue[T]) quarantine(e *entry, data []byte) error {
+	if data != nil {
+		dir := filepath.Join(q.cfg.Dir, quarantineDir)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		name := filepath.Join(dir, fmt.Sprintf("%020d.msg", e.id))
+		if err := os.WriteFile(name, data, 0o644); err != nil {
+			return err
+		}
+	}
+	if err := q.state.write(opAck, e.id); err != nil {
+		return err
+	}
+	if err := q.written(q.state.file); err != nil {
+		return err
+	}
+	e.seg.live--
+	e.seg.acked = append(e.seg.acked, e.id)
+	q.notify()
+	return q.dropSegments()
+}
+
+// settle removes an in-flight message and records op. mu must be held.
+func (q *Queue[T]) settle(m *Message[T], op byte) (*entry, error) {
+	if q.shutdown {
+		return nil, queue.ErrClosed
+	}
+	e, ok := q.inflight[m.ID]
+	if !ok {
+		return nil, fmt.Errorf("diskqueue: message %d is not in flight", m.ID)
+	}
+	if err := q.state.write(op, m.ID); err != nil {
+		return nil, fmt.Errorf("diskqueue: write state: %w", err)
+	}
+	if err := q.written(q.state.file); err != nil {
+		return nil, err
+	}
+	delete(q.inflight, m.ID)
+	return e, nil
+}
+
+// dropSegments removes segments whose messages are all acked, except the
+// one being written. mu must be held.
+func (q *Queue[T]) dropSegments() error {
+	kept := q.segments[:0]
+	removed := false
+	for i, seg := range q.segments {
+		if seg.live == 0 && i < len(q.segments)-1 {
+			if err := seg.remove(); err != nil {
+				return err
+			}
+			removed = true
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	for i := len(kept); i < len(q.segments); i++ {
+		q.segments[i] = nil
+	}
+	q.segments = kept
+	if !removed {
+		return nil
+	}
+	// forget the records of removed messages
+	nacks := map[uint64]int{}
+	for _, e := range q.ready {
+		if e.attempt > 0 {
+			nacks[e.id] = e.attempt
+		}
+	}
+	for _, e := range q.inflight {
+		if e.attempt > 0 {
+			nacks[e.id] = e.attempt
+		}
+	}
+	return q.rewriteState(nacks)
+}
+
+// rewriteState compacts the state log: the acks of the kept segments stay,
+// otherwise their messages would be delivered again after a restart.
+// mu must be held.
+func (q *Queue[T]) rewriteState(nacks map[uint64]int) error {
+	var acked []uint64
+	for _, seg := range q.segments {
+		acked = append(acked, seg.acked...)
+	}
+	return q.state.rewrite(acked, nacks)
+}
+
+// written applies the sync policy after a write. mu must be held.
+func (q *Queue[T]) written(f *os.File) error {
+	switch q.cfg.Sync {
+	case SyncAlways:
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("diskqueue: fsync: %w", err)
+		}
+	case SyncInterval:
+		q.dirty = true
+	}
+	return nil
+}
+
+func (q *Queue[T]) syncLoop() {
+	defer close(q.syncDone)
+	ticker := time.NewTicker(q.cfg.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stopSync:
+			return
+		case <-ticker.C:
+			q.mu.Lock()
+			if q.dirty && !q.shutdown {
+				// an error here surfaces on the next Shutdown sync
+				_ = q.sync()
+			}
+			q.mu.Unlock()
+		}
+	}
+}
+
+// sync flushes all files. mu must be held.
+func (q *Queue[T]) sync() error {
+	q.dirty = false
+	for _, seg := range q.segments {
+		if err := seg.file.Sync(); err != nil {
+			return fmt.Errorf("diskqueue: fsync: %w", err)
+		}
+	}
+	if err := q.state.file.Sync(); err != nil {
+		return fmt.Errorf("diskqueue: fsync: %w", err)
+	}
+	return nil
+}
+
+func (q *Queue[T]) closeFiles() error {
+	var firstErr error
+	for _, seg := range q.segments {
+		if err := seg.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if q.state != nil {
+		if err := q.state.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// notify wakes up everybody waiting for a change. mu must be held.
+func (q *Queue[T]) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
@@ -0,0 +1,315 @@
+package diskqueue_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/diskqueue"
+	"github.com/r3code/go-useful-snippets/channels/pool"
+	"github.com/r3code/go-useful-snippets/channels/queue"
+)
+
+type job struct {
+	Name string
+}
+
+func open(t *testing.T, dir string, segmentSize int64) *diskqueue.Queue[job] {
+	t.Helper()
+	q, err := diskqueue.Open(diskqueue.Config[job]{Dir: dir, SegmentSize: segmentSize, Capacity: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func enqueue(t *testing.T, q *diskqueue.Queue[job], names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := q.TryEnqueue(diskqueue.NewMessage(job{name})); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dequeue(t *testing.T, q *diskqueue.Queue[job]) *diskqueue.Message[job] {
+	t.Helper()
+	m, err := q.Dequeue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestQueue_RedeliversUnackedAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	q := open(t, dir, 0)
+	enqueue(t, q, "a", "b", "c")
+
+	if m := dequeue(t, q); m.Value.Name != "a" || m.Attempt != 1 {
+		t.Fatalf("unexpected message %+v", m)
+	} else if err := q.Ack(m); err != nil {
+		t.Fatal(err)
+	}
+	_ = dequeue(t, q) // "b" is in flight when the process "crashes"
+
+	// no Shutdown: reopen the directory as a restarted process would
+	q2 := open(t, dir, 0)
+	defer q2.Shutdown()
+	if n := q2.Len(); n != 2 {
+		t.Fatalf("want 2 messages after recovery, have %d", n)
+	}
+	if m := dequeue(t, q2); m.Value.Name != "b" {
+		t.Errorf("want b redelivered first, have %+v", m)
+	}
+	if m := dequeue(t, q2); m.Value.Name != "c" {
+		t.Errorf("want c, have %+v", m)
+	}
+	enqueue(t, q2, "d")
+	if m := dequeue(t, q2); m.ID != 4 {
+		t.Errorf("ids must not be reused after restart, have %d", m.ID)
+	}
+}
+
+// Recovery compacts the state log, the acks must survive it: every restart
+// and every removed segment rewrites the log.
+func TestQueue_AckedStayAckedAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	q := open(t, dir, 64) // a couple of records per segment
+	enqueue(t, q, "a", "b", "c", "d", "e")
+	for i := 0; i < 3; i++ {
+		if err := q.Ack(dequeue(t, q)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for restart := 1; restart <= 3; restart++ {
+		q = open(t, dir, 64)
+		if n := q.Len(); n != 2 {
+			t.Fatalf("restart %d: want 2 messages, have %d", restart, n)
+		}
+	}
+	defer q.Shutdown()
+	if m := dequeue(t, q); m.Value.Name != "d" {
+		t.Errorf("want d, have %+v", m)
+	}
+	if m := dequeue(t, q); m.Value.Name != "e" {
+		t.Errorf("want e, have %+v", m)
+	}
+}
+
+func TestQueue_NackIncreasesAttemptAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	q := open(t, dir, 0)
+	enqueue(t, q, "a")
+	if err := q.Nack(dequeue(t, q)); err != nil {
+		t.Fatal(err)
+	}
+	if m := dequeue(t, q); m.Attempt != 2 {
+		t.Errorf("want attempt 2, have %d", m.Attempt)
+	}
+	if err := q.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	q2 := open(t, dir, 0)
+	defer q2.Shutdown()
+	if m := dequeue(t, q2); m.Attempt != 2 {
+		t.Errorf("nack must survive restart, want attempt 2, have %d", m.Attempt)
+	}
+}
+
+func TestQueue_TornWriteIsTruncated(t *testing.T) {
+	dir := t.TempDir()
+	q := open(t, dir, 0)
+	enqueue(t, q, "a", "b")
+	if err := q.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	segs, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if len(segs) != 1 {
+		t.Fatalf("want 1 segment, have %v", segs)
+	}
+	// a crash in the middle of a write leaves a partial record
+	f, err := os.OpenFile(segs[0], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{42, 0, 0, 0, 1, 2, 3})
+	f.Close()
+
+	q2 := open(t, dir, 0)
+	defer q2.Shutdown()
+	enqueue(t, q2, "c")
+	var names []string
+	for q2.Len() > 0 {
+		names = append(names, dequeue(t, q2).Value.Name)
+	}
+	if len(names) != 3 || names[0] != "a" || names[1] != "b" || names[2] != "c" {
+		t.Errorf("want [a b c], have %v", names)
+	}
+}
+
+func TestQueue_AckedSegmentsAreRemoved(t *testing.T) {
+	dir := t.TempDir()
+	q := open(t, dir, 64) // a couple of records per segment
+	defer q.Shutdown()
+	for i := 0; i < 10; i++ {
+		enqueue(t, q, "some job")
+	}
+	if segs, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(segs) < 3 {
+		t.Fatalf("want several segments, have %d", len(segs))
+	}
+	for i := 0; i < 10; i++ {
+		if err := q.Ack(dequeue(t, q)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if segs, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(segs) != 1 {
+		t.Errorf("want only the active segment left, have %v", segs)
+	}
+}
+
+func TestQueue_CapacityAndClose(t *testing.T) {
+	q, err := diskqueue.Open(diskqueue.Config[job]{Dir: t.TempDir(), Capacity: 1, Sync: diskqueue.SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Shutdown()
+	enqueue(t, q, "a")
+	if err := q.TryEnqueue(diskqueue.NewMessage(job{"b"})); !errors.Is(err, queue.ErrFull) {
+		t.Errorf("want ErrFull, have %v", err)
+	}
+	q.Close()
+	if err := q.TryEnqueue(diskqueue.NewMessage(job{"b"})); !errors.Is(err, queue.ErrClosed) {
+		t.Errorf("want ErrClosed, have %v", err)
+	}
+	if m := dequeue(t, q); m.Value.Name != "a" {
+		t.Errorf("closed queue must still deliver stored messages, have %+v", m)
+	}
+}
+
+func TestQueue_WithPool(t *testing.T) {
+	dir := t.TempDir()
+	q := open(t, dir, 0)
+	var mu sync.Mutex
+	seen := map[string]int{}
+	p := pool.New(context.Background(), func(ctx context.Context, m *diskqueue.Message[job]) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[m.Value.Name]++
+		if m.Value.Name == "flaky" && pool.Attempt(ctx) == 1 {
+			return errors.New("try again")
+		}
+		return nil
+	}, pool.Config[*diskqueue.Message[job]]{
+		Workers: 2,
+		Queue:   q,
+		Retry:   pool.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+	})
+
+	for _, name := range []string{"a", "flaky", "b"} {
+		if err := p.Submit(context.Background(), diskqueue.NewMessage(job{name})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := seen["flaky"]
+		mu.Unlock()
+		if n == 2 && q.Len() == 0 && q.InFlight() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("flaky job was not retried, seen %v", seen)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	p.Stop()
+	p.Wait()
+	if err := q.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	q2 := open(t, dir, 0)
+	defer q2.Shutdown()
+	if n := q2.Len(); n != 0 {
+		t.Errorf("all messages were acked, want empty queue, have %d", n)
+	}
+}
+
+// badCodec cannot decode the job named "bad", like a message written by an
+// older version of the job type.
+type badCodec struct {
+	diskqueue.JSONCodec[job]
+}
+
+func (c badCodec) Unmarshal(data []byte) (job, error) {
+	v, err := c.JSONCodec.Unmarshal(data)
+	if err == nil && v.Name == "bad" {
+		return job{}, errors.New("unknown job")
+	}
+	return v, err
+}
+
+func TestQueue_UndecodableMessageIsQuarantined(t *testing.T) {
+	dir := t.TempDir()
+	q, err := diskqueue.Open(diskqueue.Config[job]{Dir: dir, Capacity: 100, Codec: badCodec{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueue(t, q, "a", "bad", "b")
+
+	var mu sync.Mutex
+	var processed []string
+	var reported []error
+	p := pool.New(context.Background(), func(ctx context.Context, m *diskqueue.Message[job]) error {
+		mu.Lock()
+		processed = append(processed, m.Value.Name)
+		mu.Unlock()
+		return nil
+	}, pool.Config[*diskqueue.Message[job]]{Workers: 1, Queue: q, OnError: func(_ *diskqueue.Message[job], err error) {
+		mu.Lock()
+		reported = append(reported, err)
+		mu.Unlock()
+	}})
+	deadline := time.Now().Add(2 * time.Second)
+	for q.Len() > 0 || q.InFlight() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue not drained: len %d, in flight %d, workers %d", q.Len(), q.InFlight(), p.Workers())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := p.Workers(); n != 1 {
+		t.Errorf("a bad record must not stop the workers, have %d", n)
+	}
+	p.Stop()
+	p.Wait()
+	if len(processed) != 2 || processed[0] != "a" || processed[1] != "b" {
+		t.Errorf("want [a b] processed, have %v", processed)
+	}
+	if len(reported) != 1 {
+		t.Errorf("want the bad record reported once, have %v", reported)
+	}
+	if err := q.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, _ := filepath.Glob(filepath.Join(dir, "quarantine", "*.msg"))
+	if len(saved) != 1 {
+		t.Fatalf("want the bad record in quarantine, have %v", saved)
+	}
+	if data, _ := os.ReadFile(saved[0]); string(data) != `{"Name":"bad"}` {
+		t.Errorf("quarantined payload %q", data)
+	}
+	q2 := open(t, dir, 0)
+	defer q2.Shutdown()
+	if n := q2.Len(); n != 0 {
+		t.Errorf("the quarantined record must not be delivered after a restart, have %d messages", n)
+	}
+}
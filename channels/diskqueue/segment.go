@@ -0,0 +1,153 @@
+package diskqueue
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record layout in a segment file, little endian:
+//
+//	length  uint32  payload length
+//	crc     uint32  CRC-32 (Castagnoli) of id and payload
+//	id      uint64  message id
+//	payload [length]byte
+const headerSize = 16
+
+const segmentExt = ".seg"
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// segment is one append-only data file. Its name is the id of the first
+// message it may contain, so ids stay monotonic across restarts.
+type segment struct {
+	first uint64
+	path  string
+	file  *os.File
+	size  int64
+	live  int      // messages not acked yet
+	acked []uint64 // acked ids, kept in the state log while the file exists
+}
+
+// entry is the in-memory index of a message: where its payload is stored.
+type entry struct {
+	id      uint64
+	seg     *segment
+	off     int64
+	size    int
+	attempt int
+	ready   time.Time // when the message became ready, not persisted
+}
+
+func segmentPath(dir string, first uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", first, segmentExt))
+}
+
+// listSegments returns the ids encoded in segment file names, ascending.
+func listSegments(dir string) ([]uint64, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint64, 0, len(names))
+	for _, name := range names {
+		base := strings.TrimSuffix(filepath.Base(name), segmentExt)
+		id, err := strconv.ParseUint(base, 10, 64)
+		if err != nil {
+			continue // not ours
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func openSegment(dir string, first uint64) (*segment, error) {
+	path := segmentPath(dir, first)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &segment{first: first, path: path, file: f}, nil
+}
+
+// scan reads all records of the segment. A torn or corrupted tail, left by
+// a crash in the middle of a write, is truncated.
+func (s *segment) scan(fn func(id uint64, off int64, size int)) error {
+	st, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	end := st.Size()
+	var off int64
+	hdr := make([]byte, headerSize)
+	for off+headerSize <= end {
+		if _, err := s.file.ReadAt(hdr, off); err != nil {
+			return err
+		}
+		size := int64(binary.LittleEndian.Uint32(hdr[0:4]))
+		sum := binary.LittleEndian.Uint32(hdr[4:8])
+		id := binary.LittleEndian.Uint64(hdr[8:16])
+		if off+headerSize+size > end {
+			break
+		}
+		payload := make([]byte, size)
+		if _, err := s.file.ReadAt(payload, off+headerSize); err != nil {
+			return err
+		}
+		if checksum(hdr[8:16], payload) != sum {
+			break
+		}
+		fn(id, off+headerSize, int(size))
+		off += headerSize + size
+	}
+	if off != end {
+		if err := s.file.Truncate(off); err != nil {
+			return err
+		}
+	}
+	s.size = off
+	return nil
+}
+
+// append writes a record and returns the payload offset.
+func (s *segment) append(id uint64, payload []byte) (int64, error) {
+	buf := make([]byte, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint64(buf[8:16], id)
+	copy(buf[headerSize:], payload)
+	binary.LittleEndian.PutUint32(buf[4:8], checksum(buf[8:16], payload))
+	if _, err := s.file.WriteAt(buf, s.size); err != nil {
+		return 0, err
+	}
+	off := s.size + headerSize
+	s.size += int64(len(buf))
+	return off, nil
+}
+
+func (s *segment) read(off int64, size int) ([]byte, error) {
+	buf := make([]byte, size)
+	if _, err := s.file.ReadAt(buf, off); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (s *segment) remove() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(s.path)
+}
+
+func checksum(id, payload []byte) uint32 {
+	sum := crc32.Update(0, crcTable, id)
+	return crc32.Update(sum, crcTable, payload)
+}
@@ -0,0 +1,115 @@
+package diskqueue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// The state log records what happened to delivered messages, 9 bytes each:
+// an op byte and the message id. A torn last record is ignored on recovery.
+const (
+	opAck  byte = 'A'
+	opNack byte = 'N'
+
+	stateFile   = "state.log"
+	stateRecord = 9
+)
+
+type stateLog struct {
+	path string
+	file *os.File
+}
+
+func openState(dir string) (*stateLog, error) {
+	path := filepath.Join(dir, stateFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &stateLog{path: path, file: f}, nil
+}
+
+// load returns acked ids and the number of nacks per id.
+func (l *stateLog) load() (acked map[uint64]bool, nacks map[uint64]int, err error) {
+	acked = map[uint64]bool{}
+	nacks = map[uint64]int{}
+	if _, err = l.file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(l.file)
+	rec := make([]byte, stateRecord)
+	for {
+		if _, err := io.ReadFull(r, rec); err != nil {
+			break
+		}
+		id := binary.LittleEndian.Uint64(rec[1:])
+		switch rec[0] {
+		case opAck:
+			acked[id] = true
+		case opNack:
+			nacks[id]++
+		}
+	}
+	return acked, nacks, nil
+}
+
+func (l *stateLog) write(op byte, id uint64) error {
+	rec := make([]byte, stateRecord)
+	rec[0] = op
+	binary.LittleEndian.PutUint64(rec[1:], id)
+	_, err := l.file.Write(rec)
+	return err
+}
+
+// rewrite atomically replaces the log with the acks of messages still on
+// disk and the nack counts of live messages, dropping records of messages
+// whose segments are gone.
+func (l *stateLog) rewrite(acked []uint64, nacks map[uint64]int) error {
+	tmp := l.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	rec := make([]byte, stateRecord)
+	rec[0] = opAck
+	for _, id := range acked {
+		binary.LittleEndian.PutUint64(rec[1:], id)
+		if _, err := w.Write(rec); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	rec[0] = opNack
+	for id, n := range nacks {
+		binary.LittleEndian.PutUint64(rec[1:], id)
+		for i := 0; i < n; i++ {
+			if _, err := w.Write(rec); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file, err = os.OpenFile(l.path, os.O_RDWR|os.O_APPEND, 0o644)
+	return err
+}
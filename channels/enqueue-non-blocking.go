@@ -1,5 +1,10 @@
+//go:build ignore
+
 // Way to try to enqueue a Job and report back if queue is full and its rejected to add new Job
-func TryEnqueue(job Job, jobChan <-chan Job) bool {
+// NOTE: the channel must be send-only (or bidirectional) to send into it.
+// See channels/queue for a typed queue with EnqueueTimeout, stats and a
+// 503/Retry-After HTTP middleware.
+func TryEnqueue(job Job, jobChan chan<- Job) bool {
     select {
     case jobChan <- job:
         return true
@@ -9,7 +14,7 @@ func TryEnqueue(job Job, jobChan <-chan Job) bool {
 }
 
 // Usage
-if !TryEnqueue(job, chan) {
+if !TryEnqueue(job, jobChan) {
     http.Error(w, "max capacity reached", 503)
     return
 }
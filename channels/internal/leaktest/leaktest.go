@@ -0,0 +1,32 @@
+// Package leaktest checks tests for leaked goroutines.
+//
+// Usage:
+//
+//	func TestX(t *testing.T) {
+//		defer leaktest.Check(t)()
+//		...
+//	}
+package leaktest
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Check records the number of goroutines and returns a function that fails
+// the test if goroutines started since are still running a second later.
+func Check(t testing.TB) func() {
+	before := runtime.NumGoroutine()
+	return func() {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				t.Errorf("goroutine leak: %d before, %d after", before, runtime.NumGoroutine())
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
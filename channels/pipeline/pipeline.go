@@ -0,0 +1,90 @@
+// Package pipeline provides composable channel stages (Map, Filter, Batch,
+// FanOut, Merge, Tee, Throttle) for the fan-out/fan-in code we keep writing
+// around channels.
+//
+// All stages of a pipeline share a Pipeline: the first error returned by a
+// stage function cancels the pipeline context, every stage then stops and
+// closes its output, and Wait returns that error.
+//
+// Example:
+//
+//	p := pipeline.New(ctx)
+//	ids := pipeline.From(p, 1, 2, 3, 4, 5)
+//	users := pipeline.Map(p, ids, fetchUser)
+//	batches := pipeline.Batch(p, users, 100, time.Second)
+//	for batch := range batches {
+//		save(batch)
+//	}
+//	if err := p.Wait(); err != nil {
+//		return err
+//	}
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// Pipeline is the shared cancellation and error scope of connected stages.
+type Pipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	once sync.Once
+	err  error
+}
+
+// New creates a pipeline, it is cancelled together with ctx.
+func New(ctx context.Context) *Pipeline {
+	p := &Pipeline{}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	return p
+}
+
+// Context returns the pipeline context, it is done after the first error.
+func (p *Pipeline) Context() context.Context {
+	return p.ctx
+}
+
+// Fail stops the pipeline with err, only the first error is kept.
+func (p *Pipeline) Fail(err error) {
+	if err == nil {
+		return
+	}
+	p.once.Do(func() {
+		p.err = err
+		p.cancel()
+	})
+}
+
+// Wait waits for all stages to exit and returns the first error, or the
+// parent context error if it was cancelled.
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+	p.once.Do(func() {
+		// nil after a clean finish, the parent error after its cancellation
+		p.err = p.ctx.Err()
+	})
+	p.cancel()
+	return p.err
+}
+
+// stage runs fn in a goroutine tracked by the pipeline.
+func (p *Pipeline) stage(fn func()) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		fn()
+	}()
+}
+
+// send delivers v unless the pipeline is cancelled.
+func send[T any](ctx context.Context, out chan<- T, v T) bool {
+	select {
+	case out <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,183 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/internal/leaktest"
+	"github.com/r3code/go-useful-snippets/channels/pipeline"
+)
+
+func double(_ context.Context, v int) (int, error) {
+	return v * 2, nil
+}
+
+func TestMapFilter(t *testing.T) {
+	defer leaktest.Check(t)()
+	p := pipeline.New(context.Background())
+	nums := pipeline.From(p, 1, 2, 3, 4, 5)
+	even := pipeline.Filter(p, nums, func(_ context.Context, v int) (bool, error) {
+		return v%2 == 0, nil
+	})
+	got, err := pipeline.Collect(p, pipeline.Map(p, even, double))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != 4 || got[1] != 8 {
+		t.Errorf("got %v, want [4 8]", got)
+	}
+}
+
+func TestErrorStopsAllStages(t *testing.T) {
+	defer leaktest.Check(t)()
+	boom := errors.New("boom")
+	p := pipeline.New(context.Background())
+	nums := make(chan int)
+	go func() {
+		// an endless source, only cancellation stops it
+		defer close(nums)
+		for i := 0; ; i++ {
+			select {
+			case nums <- i:
+			case <-p.Context().Done():
+				return
+			}
+		}
+	}()
+	out := pipeline.Map(p, nums, func(_ context.Context, v int) (int, error) {
+		if v == 3 {
+			return 0, boom
+		}
+		return v, nil
+	})
+	for range pipeline.Map(p, out, double) {
+	}
+	if err := p.Wait(); !errors.Is(err, boom) {
+		t.Errorf("Wait() = %v, want %v", err, boom)
+	}
+}
+
+func TestParentCancel(t *testing.T) {
+	defer leaktest.Check(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	p := pipeline.New(ctx)
+	out := pipeline.Map(p, pipeline.From(p, 1, 2, 3), double)
+	<-out
+	cancel()
+	if err := p.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() = %v, want context.Canceled", err)
+	}
+}
+
+func TestBatch_Size(t *testing.T) {
+	defer leaktest.Check(t)()
+	p := pipeline.New(context.Background())
+	got, err := pipeline.Collect(p, pipeline.Batch(p, pipeline.From(p, 1, 2, 3, 4, 5), 2, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || len(got[0]) != 2 || len(got[1]) != 2 || len(got[2]) != 1 {
+		t.Errorf("got %v, want [[1 2] [3 4] [5]]", got)
+	}
+}
+
+func TestBatch_MaxWait(t *testing.T) {
+	defer leaktest.Check(t)()
+	p := pipeline.New(context.Background())
+	in := make(chan int)
+	batches := pipeline.Batch(p, in, 10, 20*time.Millisecond)
+	in <- 1
+	in <- 2
+	select {
+	case b := <-batches:
+		if len(b) != 2 {
+			t.Errorf("got %v, want [1 2]", b)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("partial batch was not flushed after maxWait")
+	}
+	close(in)
+	if _, ok := <-batches; ok {
+		t.Error("want closed output after the input is closed")
+	}
+	if err := p.Wait(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFanOutMerge(t *testing.T) {
+	defer leaktest.Check(t)()
+	p := pipeline.New(context.Background())
+	src := pipeline.From(p, 1, 2, 3, 4, 5, 6, 7, 8)
+	var workers []<-chan int
+	for _, ch := range pipeline.FanOut(p, src, 3) {
+		workers = append(workers, pipeline.Map(p, ch, double))
+	}
+	got, err := pipeline.Collect(p, pipeline.Merge(p, workers...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(got)
+	want := []int{2, 4, 6, 8, 10, 12, 14, 16}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTee(t *testing.T) {
+	defer leaktest.Check(t)()
+	p := pipeline.New(context.Background())
+	a, b := pipeline.Tee(p, pipeline.From(p, 1, 2, 3))
+	done := make(chan []int)
+	go func() {
+		var got []int
+		for v := range b {
+			got = append(got, v)
+		}
+		done <- got
+	}()
+	gotA, err := pipeline.Collect(p, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotB := <-done
+	if len(gotA) != 3 || len(gotB) != 3 {
+		t.Errorf("got %v and %v, want 3 values in both", gotA, gotB)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	defer leaktest.Check(t)()
+	p := pipeline.New(context.Background())
+	start := time.Now()
+	got, err := pipeline.Collect(p, pipeline.Throttle(p, pipeline.From(p, 1, 2, 3, 4), 20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Errorf("got %v, want 4 values", got)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("4 values passed in %v, want at least 50ms", d)
+	}
+}
+
+func TestThrottle_ZeroIntervalPassesThrough(t *testing.T) {
+	defer leaktest.Check(t)()
+	p := pipeline.New(context.Background())
+	got, err := pipeline.Collect(p, pipeline.Throttle(p, pipeline.From(p, 1, 2, 3), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Errorf("got %v, want 3 values", got)
+	}
+}
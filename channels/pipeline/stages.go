@@ -0,0 +1,268 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// From emits items and closes the channel.
+func From[T any](p *Pipeline, items ...T) <-chan T {
+	out := make(chan T)
+	p.stage(func() {
+		defer close(out)
+		for _, v := range items {
+			if !send(p.ctx, out, v) {
+				return
+			}
+		}
+	})
+	return out
+}
+
+// Map applies fn to every value. An error from fn fails the pipeline.
+func Map[T, R any](p *Pipeline, in <-chan T, fn func(ctx context.Context, v T) (R, error)) <-chan R {
+	out := make(chan R)
+	p.stage(func() {
+		defer close(out)
+		for {
+			v, ok := recv(p, in)
+			if !ok {
+				return
+			}
+			r, err := fn(p.ctx, v)
+			if err != nil {
+				p.Fail(err)
+				return
+			}
+			if !send(p.ctx, out, r) {
+				return
+			}
+		}
+	})
+	return out
+}
+
+// Filter passes the values for which keep returns true.
+func Filter[T any](p *Pipeline, in <-chan T, keep func(ctx context.Context, v T) (bool, error)) <-chan T {
+	out := make(chan T)
+	p.stage(func() {
+		defer close(out)
+		for {
+			v, ok := recv(p, in)
+			if !ok {
+				return
+			}
+			pass, err := keep(p.ctx, v)
+			if err != nil {
+				p.Fail(err)
+				return
+			}
+			if pass && !send(p.ctx, out, v) {
+				return
+			}
+		}
+	})
+	return out
+}
+
+// Batch groups values into slices of up to size elements. A batch that is
+// not full is emitted maxWait after its first value, and when in is closed.
+func Batch[T any](p *Pipeline, in <-chan T, size int, maxWait time.Duration) <-chan []T {
+	if size <= 0 {
+		size = 1
+	}
+	out := make(chan []T)
+	p.stage(func() {
+		defer close(out)
+		var batch []T
+		var timer *time.Timer
+		var expired <-chan time.Time
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timer, expired = nil, nil
+			}
+			if len(batch) == 0 {
+				return true
+			}
+			b := batch
+			batch = nil
+			return send(p.ctx, out, b)
+		}
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				batch = append(batch, v)
+				if len(batch) >= size {
+					if !flush() {
+						return
+					}
+				} else if timer == nil && maxWait > 0 {
+					timer = time.NewTimer(maxWait)
+					expired = timer.C
+				}
+			case <-expired:
+				timer, expired = nil, nil
+				if !flush() {
+					return
+				}
+			case <-p.ctx.Done():
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
+		}
+	})
+	return out
+}
+
+// FanOut distributes the values of in over n channels; every value goes to
+// exactly one of them, whichever consumer is ready first.
+func FanOut[T any](p *Pipeline, in <-chan T, n int) []<-chan T {
+	if n <= 0 {
+		n = 1
+	}
+	outs := make([]<-chan T, n)
+	for i := range outs {
+		out := make(chan T)
+		outs[i] = out
+		p.stage(func() {
+			defer close(out)
+			for {
+				v, ok := recv(p, in)
+				if !ok {
+					return
+				}
+				if !send(p.ctx, out, v) {
+					return
+				}
+			}
+		})
+	}
+	return outs
+}
+
+// Merge combines several channels into one, closed after all inputs are.
+func Merge[T any](p *Pipeline, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+	wg.Add(len(ins))
+	for _, in := range ins {
+		in := in
+		p.stage(func() {
+			defer wg.Done()
+			for {
+				v, ok := recv(p, in)
+				if !ok {
+					return
+				}
+				if !send(p.ctx, out, v) {
+					return
+				}
+			}
+		})
+	}
+	p.stage(func() {
+		wg.Wait()
+		close(out)
+	})
+	return out
+}
+
+// Tee copies every value to both outputs. It waits for both consumers, so
+// the slower one sets the pace.
+func Tee[T any](p *Pipeline, in <-chan T) (<-chan T, <-chan T) {
+	out1, out2 := make(chan T), make(chan T)
+	p.stage(func() {
+		defer close(out1)
+		defer close(out2)
+		for {
+			v, ok := recv(p, in)
+			if !ok {
+				return
+			}
+			// send to whichever is ready first, then to the other one
+			o1, o2 := out1, out2
+			for o1 != nil || o2 != nil {
+				select {
+				case o1 <- v:
+					o1 = nil
+				case o2 <- v:
+					o2 = nil
+				case <-p.ctx.Done():
+					return
+				}
+			}
+		}
+	})
+	return out1, out2
+}
+
+// Throttle passes at most one value per every interval. With every <= 0
+// values pass without delay.
+func Throttle[T any](p *Pipeline, in <-chan T, every time.Duration) <-chan T {
+	out := make(chan T)
+	if every <= 0 {
+		p.stage(func() {
+			defer close(out)
+			for {
+				v, ok := recv(p, in)
+				if !ok || !send(p.ctx, out, v) {
+					return
+				}
+			}
+		})
+		return out
+	}
+	p.stage(func() {
+		defer close(out)
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		first := true
+		for {
+			v, ok := recv(p, in)
+			if !ok {
+				return
+			}
+			if !first {
+				select {
+				case <-ticker.C:
+				case <-p.ctx.Done():
+					return
+				}
+			}
+			first = false
+			if !send(p.ctx, out, v) {
+				return
+			}
+		}
+	})
+	return out
+}
+
+// Collect reads in until it is closed and returns the values with the
+// pipeline result from Wait.
+func Collect[T any](p *Pipeline, in <-chan T) ([]T, error) {
+	var all []T
+	for v := range in {
+		all = append(all, v)
+	}
+	return all, p.Wait()
+}
+
+// recv reads from in unless the pipeline is cancelled.
+func recv[T any](p *Pipeline, in <-chan T) (T, bool) {
+	select {
+	case v, ok := <-in:
+		return v, ok
+	case <-p.ctx.Done():
+		var zero T
+		return zero, false
+	}
+}
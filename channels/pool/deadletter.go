@@ -0,0 +1,100 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter is a job that failed on its last attempt.
+type DeadLetter[T any] struct {
+	Job      T
+	Err      error
+	Attempts int
+	FailedAt time.Time
+}
+
+// DeadLetterSink stores jobs that exhausted their retries.
+type DeadLetterSink[T any] interface {
+	Put(ctx context.Context, dl DeadLetter[T]) error
+}
+
+// DeadLetterFunc adapts a function to DeadLetterSink.
+type DeadLetterFunc[T any] func(ctx context.Context, dl DeadLetter[T]) error
+
+// Put implements DeadLetterSink.
+func (f DeadLetterFunc[T]) Put(ctx context.Context, dl DeadLetter[T]) error {
+	return f(ctx, dl)
+}
+
+// DeadLetterChan is a DeadLetterSink sending to a channel. Put blocks until
+// the channel accepts the job or the pool is cancelled.
+type DeadLetterChan[T any] chan<- DeadLetter[T]
+
+// Put implements DeadLetterSink.
+func (ch DeadLetterChan[T]) Put(ctx context.Context, dl DeadLetter[T]) error {
+	select {
+	case ch <- dl:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DeadLetterFile appends dead jobs to a file as JSON lines:
+//
+//	{"job":{...},"error":"...","attempts":3,"failed_at":"2020-01-02T15:04:05Z"}
+type DeadLetterFile[T any] struct {
+	mu   sync.Mutex
+	f    *os.File
+	sync bool
+}
+
+type deadLetterRecord[T any] struct {
+	Job      T         `json:"job"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// NewDeadLetterFile opens path for appending. With fsync every record is
+// flushed to disk before Put returns.
+func NewDeadLetterFile[T any](path string, fsync bool) (*DeadLetterFile[T], error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetterFile[T]{f: f, sync: fsync}, nil
+}
+
+// Put implements DeadLetterSink.
+func (d *DeadLetterFile[T]) Put(ctx context.Context, dl DeadLetter[T]) error {
+	line, err := json.Marshal(deadLetterRecord[T]{
+		Job:      dl.Job,
+		Error:    dl.Err.Error(),
+		Attempts: dl.Attempts,
+		FailedAt: dl.FailedAt,
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.f.Write(line); err != nil {
+		return err
+	}
+	if d.sync {
+		return d.f.Sync()
+	}
+	return nil
+}
+
+// Close closes the file.
+func (d *DeadLetterFile[T]) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.f.Close()
+}
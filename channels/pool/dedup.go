@@ -0,0 +1,260 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrDuplicate is returned by Dedup.Submit in Reject mode for a job whose
+// idempotency key is already known.
+var ErrDuplicate = errors.New("pool: duplicate job")
+
+// ErrUntracked is the result of the ticket of a job without a key: Dedup
+// cannot tell it from other jobs, the job runs but its result is not
+// reported.
+var ErrUntracked = errors.New("pool: job result is not tracked")
+
+// DedupMode selects what Dedup.Submit does with a duplicate job.
+type DedupMode int
+
+const (
+	// Coalesce does not submit the duplicate and returns the ticket of the
+	// original job, so both callers get the same result.
+	Coalesce DedupMode = iota
+	// Reject does not submit the duplicate and returns ErrDuplicate together
+	// with the ticket of the original job.
+	Reject
+)
+
+// DedupConfig holds the Dedup settings.
+type DedupConfig[T any] struct {
+	// KeyOf returns the idempotency key of a job, e.g. the Idempotency-Key
+	// header of the request that created it. Jobs with an empty key are
+	// never deduplicated and not tracked, their tickets are resolved at once
+	// with ErrUntracked.
+	KeyOf func(job T) string
+	// Mode is the duplicate handling, Coalesce by default.
+	Mode DedupMode
+	// TTL is how long the keys of succeeded jobs are remembered, together
+	// with their results. With zero a key is forgotten as soon as its job
+	// ends. Keys of failed and dropped jobs are always forgotten at once, so
+	// a retry by the client runs the job again.
+	TTL time.Duration
+}
+
+// Dedup deduplicates jobs by idempotency key: a job whose key is pending, in
+// flight or, within TTL, succeeded is not submitted again, its caller gets
+// the ticket of the original job instead.
+//
+// Dedup needs its Handler wrapper to record results and its Hooks methods to
+// learn how jobs end, both on the same pool:
+//
+//	d := pool.NewDedup[Job, Receipt](pool.DedupConfig[Job]{
+//		KeyOf: func(j Job) string { return j.RequestID },
+//		TTL:   time.Hour,
+//	})
+//	p := pool.New(ctx, d.Handler(charge), pool.Config[Job]{Hooks: d})
+//
+//	t, err := d.Submit(ctx, job, p.Submit)
+//	...
+//	receipt, err := t.Wait(ctx)
+type Dedup[T, R any] struct {
+	NopHooks[T]
+	keyOf func(job T) string
+	mode  DedupMode
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	tickets map[string]*Ticket[R]
+	expiry  []expiringKey // in completion order, which is expiry order
+}
+
+type expiringKey struct {
+	key string
+	at  time.Time
+}
+
+// Ticket is the pending or known result of a job submitted through Dedup.
+type Ticket[R any] struct {
+	done  chan struct{}
+	value R
+	err   error
+}
+
+func newTicket[R any]() *Ticket[R] {
+	return &Ticket[R]{done: make(chan struct{})}
+}
+
+// Done is closed when the result is known.
+func (t *Ticket[R]) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait blocks until the job ends or ctx is done, and returns the value
+// returned by the job, or its error.
+func (t *Ticket[R]) Wait(ctx context.Context) (R, error) {
+	select {
+	case <-t.done:
+		return t.value, t.err
+	case <-ctx.Done():
+		var zero R
+		return zero, ctx.Err()
+	}
+}
+
+// NewDedup creates an empty key registry.
+func NewDedup[T, R any](cfg DedupConfig[T]) *Dedup[T, R] {
+	return &Dedup[T, R]{
+		keyOf:   cfg.KeyOf,
+		mode:    cfg.Mode,
+		ttl:     cfg.TTL,
+		tickets: map[string]*Ticket[R]{},
+	}
+}
+
+// Submit submits the job with submit, usually Pool.Submit, unless its key is
+// already known. For a duplicate it returns the ticket of the original job,
+// with ErrDuplicate in Reject mode. When submit fails its error is returned
+// and the key is forgotten. A job without a key is submitted as is, its
+// ticket is resolved at once with ErrUntracked.
+func (d *Dedup[T, R]) Submit(ctx context.Context, job T, submit func(ctx context.Context, job T) error) (*Ticket[R], error) {
+	key := d.keyOf(job)
+	t := newTicket[R]()
+	if key == "" {
+		// equal jobs may be in flight at once, their results cannot be
+		// told apart
+		t.err = ErrUntracked
+		close(t.done)
+		if err := submit(ctx, job); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	d.mu.Lock()
+	d.expire()
+	if orig, ok := d.tickets[key]; ok {
+		d.mu.Unlock()
+		if d.mode == Reject {
+			return orig, ErrDuplicate
+		}
+		return orig, nil
+	}
+	d.tickets[key] = t
+	d.mu.Unlock()
+	if err := submit(ctx, job); err != nil {
+		// usually done by Dropped already
+		d.mu.Lock()
+		d.complete(job, t, *new(R), err)
+		d.mu.Unlock()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Len returns the number of known keys, pending and remembered.
+func (d *Dedup[T, R]) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire()
+	return len(d.tickets)
+}
+
+// Handler adapts fn to the pool, recording its value as the result of the
+// job's ticket.
+func (d *Dedup[T, R]) Handler(fn Func[T, R]) Handler[T] {
+	return func(ctx context.Context, job T) error {
+		v, err := fn(ctx, job)
+		if err == nil {
+			d.mu.Lock()
+			if t := d.pending(job); t != nil {
+				t.value = v
+			}
+			d.mu.Unlock()
+		}
+		return err
+	}
+}
+
+// Finished implements Hooks: the ticket gets the recorded value.
+func (d *Dedup[T, R]) Finished(_ context.Context, ev Event[T]) {
+	d.finish(ev.Job, nil)
+}
+
+// Failed implements Hooks: the ticket gets the error.
+func (d *Dedup[T, R]) Failed(_ context.Context, ev Event[T]) {
+	d.finish(ev.Job, ev.Err)
+}
+
+// Dropped implements Hooks: the ticket gets the drop reason.
+func (d *Dedup[T, R]) Dropped(_ context.Context, ev Event[T]) {
+	d.finish(ev.Job, ev.Err)
+}
+
+// finish completes the pending ticket of the job, if any.
+func (d *Dedup[T, R]) finish(job T, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t := d.pending(job)
+	if t == nil {
+		return
+	}
+	v := t.value
+	if err != nil {
+		v = *new(R)
+	}
+	d.complete(job, t, v, err)
+}
+
+// pending returns the pending ticket of the job, nil for an unknown or
+// untracked job or a completed one. mu must be held.
+func (d *Dedup[T, R]) pending(job T) *Ticket[R] {
+	key := d.keyOf(job)
+	if key == "" {
+		return nil
+	}
+	t := d.tickets[key]
+	if t == nil {
+		return nil
+	}
+	select {
+	case <-t.done:
+		return nil
+	default:
+		return t
+	}
+}
+
+// complete sets the result of the job's ticket t and forgets or remembers
+// its key. mu must be held.
+func (d *Dedup[T, R]) complete(job T, t *Ticket[R], v R, err error) {
+	select {
+	case <-t.done:
+		return
+	default:
+	}
+	t.value, t.err = v, err
+	close(t.done)
+	key := d.keyOf(job)
+	if d.tickets[key] != t {
+		return
+	}
+	if err != nil || d.ttl <= 0 {
+		delete(d.tickets, key)
+		return
+	}
+	d.expiry = append(d.expiry, expiringKey{key: key, at: time.Now().Add(d.ttl)})
+}
+
+// expire forgets the keys remembered longer than TTL. mu must be held.
+func (d *Dedup[T, R]) expire() {
+	now := time.Now()
+	n := 0
+	for ; n < len(d.expiry) && !d.expiry[n].at.After(now); n++ {
+		delete(d.tickets, d.expiry[n].key)
+	}
+	if n > 0 {
+		d.expiry = append(d.expiry[:0], d.expiry[n:]...)
+	}
+}
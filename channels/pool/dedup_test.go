@@ -0,0 +1,178 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/pool"
+)
+
+type order struct {
+	key  string
+	fail bool
+}
+
+func newDedupPool(t *testing.T, cfg pool.DedupConfig[order], release <-chan struct{}) (*pool.Dedup[order, string], *pool.Pool[order], *int64) {
+	t.Helper()
+	cfg.KeyOf = func(o order) string { return o.key }
+	d := pool.NewDedup[order, string](cfg)
+	var runs int64
+	p := pool.New(context.Background(), d.Handler(func(ctx context.Context, o order) (string, error) {
+		n := atomic.AddInt64(&runs, 1)
+		if release != nil {
+			<-release
+		}
+		if o.fail {
+			return "", errors.New("declined")
+		}
+		return strings.Repeat("+", int(n)), nil
+	}), pool.Config[order]{Workers: 2, QueueSize: 4, Hooks: d})
+	t.Cleanup(func() {
+		p.Stop()
+		p.Wait()
+	})
+	return d, p, &runs
+}
+
+func TestDedup_Coalesce(t *testing.T) {
+	release := make(chan struct{})
+	d, p, runs := newDedupPool(t, pool.DedupConfig[order]{}, release)
+	ctx := context.Background()
+
+	t1, err := d.Submit(ctx, order{key: "a"}, p.Submit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t2, err := d.Submit(ctx, order{key: "a"}, p.Submit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if t1 != t2 {
+		t.Error("want the ticket of the original job for a duplicate")
+	}
+	close(release)
+	v, err := t2.Wait(ctx)
+	if err != nil || v != "+" {
+		t.Errorf("Wait() = %q, %v, want +", v, err)
+	}
+	if n := atomic.LoadInt64(runs); n != 1 {
+		t.Errorf("job ran %d times, want once", n)
+	}
+	// no TTL: the key is forgotten once the job ended
+	if n := d.Len(); n != 0 {
+		t.Errorf("Len() = %d, want 0", n)
+	}
+}
+
+func TestDedup_Reject(t *testing.T) {
+	release := make(chan struct{})
+	d, p, _ := newDedupPool(t, pool.DedupConfig[order]{Mode: pool.Reject}, release)
+	ctx := context.Background()
+
+	orig, _ := d.Submit(ctx, order{key: "a"}, p.Submit)
+	dup, err := d.Submit(ctx, order{key: "a"}, p.Submit)
+	if !errors.Is(err, pool.ErrDuplicate) || dup != orig {
+		t.Errorf("want ErrDuplicate with the original ticket, have %v", err)
+	}
+	// other keys are not affected
+	if _, err := d.Submit(ctx, order{key: "b"}, p.Submit); err != nil {
+		t.Error(err)
+	}
+	close(release)
+}
+
+func TestDedup_TTL(t *testing.T) {
+	d, p, runs := newDedupPool(t, pool.DedupConfig[order]{TTL: 50 * time.Millisecond}, nil)
+	ctx := context.Background()
+
+	t1, _ := d.Submit(ctx, order{key: "a"}, p.Submit)
+	if v, _ := t1.Wait(ctx); v != "+" {
+		t.Fatalf("first result %q", v)
+	}
+	late, err := d.Submit(ctx, order{key: "a"}, p.Submit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := late.Wait(ctx); v != "+" || err != nil {
+		t.Errorf("late duplicate got %q, %v, want the original result", v, err)
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	again, _ := d.Submit(ctx, order{key: "a"}, p.Submit)
+	if v, _ := again.Wait(ctx); v != "++" {
+		t.Errorf("after TTL got %q, want a new run", v)
+	}
+	if n := atomic.LoadInt64(runs); n != 2 {
+		t.Errorf("job ran %d times, want 2", n)
+	}
+}
+
+func TestDedup_FailureForgetsKey(t *testing.T) {
+	d, p, runs := newDedupPool(t, pool.DedupConfig[order]{TTL: time.Hour}, nil)
+	ctx := context.Background()
+
+	t1, _ := d.Submit(ctx, order{key: "a", fail: true}, p.Submit)
+	if _, err := t1.Wait(ctx); err == nil {
+		t.Fatal("want the job error")
+	}
+	t2, _ := d.Submit(ctx, order{key: "a"}, p.Submit)
+	if _, err := t2.Wait(ctx); err != nil {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt64(runs); n != 2 {
+		t.Errorf("job ran %d times, want 2", n)
+	}
+}
+
+func TestDedup_SubmitError(t *testing.T) {
+	d, p, _ := newDedupPool(t, pool.DedupConfig[order]{}, nil)
+	p.Stop()
+	if _, err := d.Submit(context.Background(), order{key: "a"}, p.Submit); err == nil {
+		t.Fatal("want the Submit error")
+	}
+	if n := d.Len(); n != 0 {
+		t.Errorf("Len() = %d, want the key forgotten", n)
+	}
+}
+
+func TestDedup_EmptyKeyUntracked(t *testing.T) {
+	release := make(chan struct{})
+	d, p, runs := newDedupPool(t, pool.DedupConfig[order]{}, release)
+	ctx := context.Background()
+
+	// equal jobs in flight at once: their results cannot be told apart
+	var tickets []*pool.Ticket[string]
+	for i := 0; i < 2; i++ {
+		tk, err := d.Submit(ctx, order{}, p.Submit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tickets = append(tickets, tk)
+	}
+	if tickets[0] == tickets[1] {
+		t.Error("jobs without a key must not be deduplicated")
+	}
+	for _, tk := range tickets {
+		select {
+		case <-tk.Done():
+		default:
+			t.Fatal("want the ticket resolved at once")
+		}
+		if _, err := tk.Wait(ctx); !errors.Is(err, pool.ErrUntracked) {
+			t.Errorf("want ErrUntracked, have %v", err)
+		}
+	}
+	close(release)
+	p.Stop()
+	p.Wait()
+	if n := atomic.LoadInt64(runs); n != 2 {
+		t.Errorf("want 2 runs, have %d", n)
+	}
+	if n := d.Len(); n != 0 {
+		t.Errorf("want no keys, have %d", n)
+	}
+}
@@ -0,0 +1,119 @@
+package pool
+
+import (
+	"context"
+	"time"
+)
+
+// Event describes a job at one point of its way through the pool.
+type Event[T any] struct {
+	Job T
+	// WorkerID is the worker running the job, 0 before a worker took it.
+	WorkerID int
+	// Attempt is the attempt number, see Attempt.
+	Attempt int
+	// Wait is how long the job waited between its enqueue and its start,
+	// including rate and key limits; -1 when the queue does not implement
+	// queue.Timed.
+	Wait time.Duration
+	// Duration is the Handler time of the attempt for Retried, and the time
+	// since the start, retries and backoff included, for Finished, Failed
+	// and a Dropped job that was started.
+	Duration time.Duration
+	// Err is the error of Retried, Failed and Dropped.
+	Err error
+}
+
+// Hooks observes the jobs of a pool, e.g. to collect metrics (see
+// channels/pool/instrument). Every job passes Enqueued, then Started and
+// ends with exactly one of Finished, Failed or Dropped; a job rejected by
+// Submit only gets Dropped. Methods are called from the submitting
+// goroutine or the worker and must not block; Enqueued may run concurrently
+// with Started of the same job.
+type Hooks[T any] interface {
+	// Enqueued is called after Submit or TrySubmit accepted the job, ctx is
+	// the Submit context.
+	Enqueued(ctx context.Context, ev Event[T])
+	// Started is called before the first attempt. The returned context is
+	// passed to the Handler and to the other hooks of this job, e.g. with a
+	// tracing span.
+	Started(ctx context.Context, ev Event[T]) context.Context
+	// Retried is called after a failed attempt that will be retried.
+	Retried(ctx context.Context, ev Event[T])
+	// Finished is called after the Handler succeeded.
+	Finished(ctx context.Context, ev Event[T])
+	// Failed is called for a job that finally failed, whether or not a dead
+	// letter sink took it.
+	Failed(ctx context.Context, ev Event[T])
+	// Dropped is called for a job rejected by Submit (Err is the Submit
+	// error) or not processed because the pool was cancelled (Err is
+	// context.Canceled) or aborted (Err is queue.ErrClosed).
+	Dropped(ctx context.Context, ev Event[T])
+}
+
+// NopHooks implements Hooks doing nothing, embed it to implement only some
+// of the methods.
+type NopHooks[T any] struct{}
+
+// Enqueued implements Hooks.
+func (NopHooks[T]) Enqueued(context.Context, Event[T]) {}
+
+// Started implements Hooks.
+func (NopHooks[T]) Started(ctx context.Context, _ Event[T]) context.Context { return ctx }
+
+// Retried implements Hooks.
+func (NopHooks[T]) Retried(context.Context, Event[T]) {}
+
+// Finished implements Hooks.
+func (NopHooks[T]) Finished(context.Context, Event[T]) {}
+
+// Failed implements Hooks.
+func (NopHooks[T]) Failed(context.Context, Event[T]) {}
+
+// Dropped implements Hooks.
+func (NopHooks[T]) Dropped(context.Context, Event[T]) {}
+
+// MultiHooks calls every hook in turn; the context returned by Started of
+// one is passed to the next.
+func MultiHooks[T any](hooks ...Hooks[T]) Hooks[T] {
+	return multiHooks[T](hooks)
+}
+
+type multiHooks[T any] []Hooks[T]
+
+func (m multiHooks[T]) Enqueued(ctx context.Context, ev Event[T]) {
+	for _, h := range m {
+		h.Enqueued(ctx, ev)
+	}
+}
+
+func (m multiHooks[T]) Started(ctx context.Context, ev Event[T]) context.Context {
+	for _, h := range m {
+		ctx = h.Started(ctx, ev)
+	}
+	return ctx
+}
+
+func (m multiHooks[T]) Retried(ctx context.Context, ev Event[T]) {
+	for _, h := range m {
+		h.Retried(ctx, ev)
+	}
+}
+
+func (m multiHooks[T]) Finished(ctx context.Context, ev Event[T]) {
+	for _, h := range m {
+		h.Finished(ctx, ev)
+	}
+}
+
+func (m multiHooks[T]) Failed(ctx context.Context, ev Event[T]) {
+	for _, h := range m {
+		h.Failed(ctx, ev)
+	}
+}
+
+func (m multiHooks[T]) Dropped(ctx context.Context, ev Event[T]) {
+	for _, h := range m {
+		h.Dropped(ctx, ev)
+	}
+}
@@ -0,0 +1,69 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/pool"
+	"github.com/r3code/go-useful-snippets/channels/queue"
+)
+
+// eventLog records the hook calls as "event job".
+type eventLog struct {
+	pool.NopHooks[int]
+	mu     sync.Mutex
+	events []string
+	errs   []error
+}
+
+func (l *eventLog) add(name string, ev pool.Event[int]) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, fmt.Sprintf("%s %d", name, ev.Job))
+	if ev.Err != nil {
+		l.errs = append(l.errs, ev.Err)
+	}
+}
+
+func (l *eventLog) Started(ctx context.Context, ev pool.Event[int]) context.Context {
+	l.add("started", ev)
+	return ctx
+}
+
+func (l *eventLog) Finished(_ context.Context, ev pool.Event[int]) { l.add("finished", ev) }
+
+func (l *eventLog) Dropped(_ context.Context, ev pool.Event[int]) { l.add("dropped", ev) }
+
+func TestPool_HooksAbort(t *testing.T) {
+	log := &eventLog{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		close(started)
+		<-release
+		return nil
+	}, pool.Config[int]{Workers: 1, QueueSize: 2, Hooks: log})
+	_ = p.Submit(context.Background(), 0)
+	<-started
+	_ = p.Submit(context.Background(), 1)
+	go func() {
+		// let Shutdown set the abort flag first
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	if _, err := p.Shutdown(context.Background(), pool.Abort); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"started 0", "finished 0", "dropped 1"}
+	if fmt.Sprint(log.events) != fmt.Sprint(want) {
+		t.Errorf("events %v, want %v", log.events, want)
+	}
+	if len(log.errs) != 1 || !errors.Is(log.errs[0], queue.ErrClosed) {
+		t.Errorf("drop errors %v, want queue.ErrClosed", log.errs)
+	}
+}
@@ -0,0 +1,14 @@
+package instrument
+
+import "expvar"
+
+// Var returns the metrics as an expvar.Var, its value is Snapshot in JSON.
+func (m *Metrics[T]) Var() expvar.Var {
+	return expvar.Func(func() any { return m.Snapshot() })
+}
+
+// Publish publishes the metrics in expvar under name. Like expvar.Publish
+// it panics if the name is already registered.
+func (m *Metrics[T]) Publish(name string) {
+	expvar.Publish(name, m.Var())
+}
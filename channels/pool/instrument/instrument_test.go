@@ -0,0 +1,225 @@
+package instrument_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/r3code/go-useful-snippets/channels/pool"
+	"github.com/r3code/go-useful-snippets/channels/pool/instrument"
+)
+
+type job struct {
+	kind   string
+	fail   bool
+	parent trace.SpanContext
+}
+
+var errJob = errors.New("job failed")
+
+func runJobs(t *testing.T, hooks pool.Hooks[job], jobs ...job) {
+	t.Helper()
+	p := pool.New(context.Background(), func(ctx context.Context, j job) error {
+		time.Sleep(time.Millisecond)
+		if j.fail {
+			return errJob
+		}
+		return nil
+	}, pool.Config[job]{
+		Workers:   2,
+		QueueSize: len(jobs),
+		Retry:     pool.RetryPolicy{MaxAttempts: 2},
+		Hooks:     hooks,
+	})
+	for _, j := range jobs {
+		if err := p.Submit(context.Background(), j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Stop()
+	p.Wait()
+}
+
+func TestMetrics(t *testing.T) {
+	m := instrument.NewMetrics(instrument.MetricsConfig[job]{
+		TypeOf: func(j job) string { return j.kind },
+	})
+	runJobs(t, m, job{kind: "email"}, job{kind: "email"}, job{kind: "sms", fail: true})
+
+	snap := m.Snapshot()
+	email, sms := snap["email"], snap["sms"]
+	if email.Enqueued != 2 || email.Started != 2 || email.Finished != 2 || email.Failed != 0 {
+		t.Errorf("email counts %+v", email.Counts)
+	}
+	if sms.Started != 1 || sms.Retried != 1 || sms.Failed != 1 {
+		t.Errorf("sms counts %+v", sms.Counts)
+	}
+	if n := email.Wait.Count(); n != 2 {
+		t.Errorf("email wait observations = %d, want 2", n)
+	}
+	if n := email.Latency.Count(); n != 2 || email.Latency.Sum <= 0 {
+		t.Errorf("email latency count %d sum %v", n, email.Latency.Sum)
+	}
+
+	var out strings.Builder
+	if err := m.WritePrometheus(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE pool_jobs_total counter",
+		`pool_jobs_total{type="email",event="finished"} 2`,
+		`pool_jobs_total{type="sms",event="failed"} 1`,
+		"# TYPE pool_job_wait_seconds histogram",
+		`pool_job_wait_seconds_bucket{type="email",le="+Inf"} 2`,
+		`pool_job_duration_seconds_count{type="sms"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("exposition has no line %q:\n%s", line, out.String())
+		}
+	}
+
+	var fromVar map[string]instrument.TypeStats
+	if err := json.Unmarshal([]byte(m.Var().String()), &fromVar); err != nil {
+		t.Fatal(err)
+	}
+	if fromVar["email"].Finished != 2 {
+		t.Errorf("expvar value %+v", fromVar)
+	}
+}
+
+func TestMetrics_Dropped(t *testing.T) {
+	m := instrument.NewMetrics(instrument.MetricsConfig[job]{})
+	block := make(chan struct{})
+	p := pool.New(context.Background(), func(ctx context.Context, j job) error {
+		<-block
+		return nil
+	}, pool.Config[job]{Workers: 1, QueueSize: 1, Hooks: m})
+	p.Submit(context.Background(), job{})
+	// the worker holds the first job, the second one fills the queue
+	p.Submit(context.Background(), job{})
+	deadline := time.Now().Add(time.Second)
+	for p.TrySubmit(job{}) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("queue did not fill up")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(block)
+	p.Stop()
+	p.Wait()
+	c := m.Snapshot()["default"].Counts
+	if c.Dropped == 0 || c.Enqueued != c.Finished {
+		t.Errorf("counts %+v, want a drop and every enqueued job finished", c)
+	}
+}
+
+// fakeTracer records the spans it creates.
+type fakeTracer struct {
+	noop.Tracer
+	mu    sync.Mutex
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	s := &fakeSpan{name: name, parent: trace.SpanContextFromContext(ctx)}
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+type fakeSpan struct {
+	noop.Span
+	mu     sync.Mutex
+	name   string
+	parent trace.SpanContext
+	events []string
+	status codes.Code
+	ended  bool
+}
+
+func (s *fakeSpan) AddEvent(name string, _ ...trace.EventOption) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, name)
+}
+
+func (s *fakeSpan) SetStatus(code codes.Code, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = code
+}
+
+func (s *fakeSpan) End(...trace.SpanEndOption) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &fakeTracer{}
+	hooks := instrument.NewTracer(instrument.TracerConfig[job]{
+		Tracer:   tracer,
+		SpanName: func(j job) string { return "job " + j.kind },
+	})
+	runJobs(t, hooks, job{kind: "ok"}, job{kind: "bad", fail: true})
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(tracer.spans))
+	}
+	for _, s := range tracer.spans {
+		if !s.ended {
+			t.Errorf("span %q not ended", s.name)
+		}
+		switch s.name {
+		case "job ok":
+			if s.status == codes.Error || len(s.events) != 0 {
+				t.Errorf("span %q: status %v, events %v", s.name, s.status, s.events)
+			}
+		case "job bad":
+			if s.status != codes.Error || len(s.events) != 1 || s.events[0] != "pool.retry" {
+				t.Errorf("span %q: status %v, events %v", s.name, s.status, s.events)
+			}
+		default:
+			t.Errorf("unexpected span %q", s.name)
+		}
+	}
+}
+
+func TestTracer_ParentFromJob(t *testing.T) {
+	tracer := &fakeTracer{}
+	hooks := instrument.NewTracer(instrument.TracerConfig[job]{
+		Tracer:   tracer,
+		SpanName: func(j job) string { return j.kind },
+		Parent:   func(j job) trace.SpanContext { return j.parent },
+	})
+	producer := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{2},
+	})
+	runJobs(t, hooks, job{kind: "traced", parent: producer}, job{kind: "plain"})
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(tracer.spans))
+	}
+	for _, s := range tracer.spans {
+		switch s.name {
+		case "traced":
+			if !s.parent.Equal(producer.WithRemote(true)) {
+				t.Errorf("span %q: parent %v, want the producer span", s.name, s.parent)
+			}
+		case "plain":
+			if s.parent.IsValid() {
+				t.Errorf("span %q: unexpected parent %v", s.name, s.parent)
+			}
+		}
+	}
+}
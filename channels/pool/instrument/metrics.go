@@ -0,0 +1,196 @@
+// Package instrument provides pool.Hooks adapters: Metrics counts jobs and
+// records queue wait and processing latency histograms per job type, and
+// exposes them through expvar and the Prometheus text format; Tracer runs
+// every job in a tracing span.
+//
+// Example:
+//
+//	metrics := instrument.NewMetrics(instrument.MetricsConfig[Job]{
+//		TypeOf: func(j Job) string { return j.Kind },
+//	})
+//	http.Handle("/metrics", metrics)
+//
+//	p := pool.New(ctx, handle, pool.Config[Job]{
+//		Hooks: pool.MultiHooks[Job](metrics, instrument.NewTracer(instrument.TracerConfig[Job]{
+//			Tracer: otel.Tracer("jobs"),
+//			Parent: func(j Job) trace.SpanContext { return j.Trace },
+//		})),
+//	})
+//
+//	job.Trace = trace.SpanContextFromContext(ctx)
+//	err := p.Submit(ctx, job)
+package instrument
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/pool"
+)
+
+// DefaultBuckets are the default histogram upper bounds in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// MetricsConfig holds the Metrics settings. The zero value is usable.
+type MetricsConfig[T any] struct {
+	// TypeOf returns the job type the metrics are split by; all jobs are of
+	// type "default" when nil.
+	TypeOf func(job T) string
+	// Namespace is the prefix of the Prometheus metric names, "pool" by
+	// default.
+	Namespace string
+	// Buckets are the histogram upper bounds in seconds, sorted,
+	// DefaultBuckets by default.
+	Buckets []float64
+}
+
+// Metrics is a pool.Hooks collecting job counters and histograms.
+type Metrics[T any] struct {
+	typeOf    func(job T) string
+	namespace string
+	buckets   []float64
+
+	mu    sync.Mutex
+	types map[string]*TypeStats
+}
+
+// Counts are the numbers of job events, see pool.Hooks.
+type Counts struct {
+	Enqueued uint64
+	Started  uint64
+	Retried  uint64
+	Finished uint64
+	Failed   uint64
+	Dropped  uint64
+}
+
+// Histogram is a distribution of durations in seconds.
+type Histogram struct {
+	// Buckets are the upper bounds, Counts[i] is the number of observations
+	// not greater than Buckets[i] (cumulative), the last element of Counts
+	// counts all observations.
+	Buckets []float64
+	Counts  []uint64
+	Sum     float64
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	return h.Counts[len(h.Counts)-1]
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	h.Sum += s
+	for i := sort.SearchFloat64s(h.Buckets, s); i < len(h.Counts); i++ {
+		h.Counts[i]++
+	}
+}
+
+func (h *Histogram) clone() Histogram {
+	c := *h
+	c.Counts = append([]uint64(nil), h.Counts...)
+	return c
+}
+
+// TypeStats are the metrics of one job type.
+type TypeStats struct {
+	Counts
+	// Wait is the time jobs waited in the queue before a worker started
+	// them. Only observed when the queue implements queue.Timed.
+	Wait Histogram
+	// Latency is the processing time of finished and failed jobs, retries
+	// included.
+	Latency Histogram
+}
+
+var _ pool.Hooks[int] = (*Metrics[int])(nil)
+
+// NewMetrics creates empty metrics.
+func NewMetrics[T any](cfg MetricsConfig[T]) *Metrics[T] {
+	if cfg.TypeOf == nil {
+		cfg.TypeOf = func(T) string { return "default" }
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = "pool"
+	}
+	if len(cfg.Buckets) == 0 {
+		cfg.Buckets = DefaultBuckets
+	}
+	return &Metrics[T]{
+		typeOf:    cfg.TypeOf,
+		namespace: cfg.Namespace,
+		buckets:   cfg.Buckets,
+		types:     map[string]*TypeStats{},
+	}
+}
+
+// Snapshot returns a copy of the metrics by job type.
+func (m *Metrics[T]) Snapshot() map[string]TypeStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]TypeStats, len(m.types))
+	for name, st := range m.types {
+		out[name] = TypeStats{Counts: st.Counts, Wait: st.Wait.clone(), Latency: st.Latency.clone()}
+	}
+	return out
+}
+
+// Enqueued implements pool.Hooks.
+func (m *Metrics[T]) Enqueued(_ context.Context, ev pool.Event[T]) {
+	m.update(ev.Job, func(st *TypeStats) { st.Enqueued++ })
+}
+
+// Started implements pool.Hooks.
+func (m *Metrics[T]) Started(ctx context.Context, ev pool.Event[T]) context.Context {
+	m.update(ev.Job, func(st *TypeStats) {
+		st.Started++
+		if ev.Wait >= 0 {
+			st.Wait.observe(ev.Wait)
+		}
+	})
+	return ctx
+}
+
+// Retried implements pool.Hooks.
+func (m *Metrics[T]) Retried(_ context.Context, ev pool.Event[T]) {
+	m.update(ev.Job, func(st *TypeStats) { st.Retried++ })
+}
+
+// Finished implements pool.Hooks.
+func (m *Metrics[T]) Finished(_ context.Context, ev pool.Event[T]) {
+	m.update(ev.Job, func(st *TypeStats) {
+		st.Finished++
+		st.Latency.observe(ev.Duration)
+	})
+}
+
+// Failed implements pool.Hooks.
+func (m *Metrics[T]) Failed(_ context.Context, ev pool.Event[T]) {
+	m.update(ev.Job, func(st *TypeStats) {
+		st.Failed++
+		st.Latency.observe(ev.Duration)
+	})
+}
+
+// Dropped implements pool.Hooks.
+func (m *Metrics[T]) Dropped(_ context.Context, ev pool.Event[T]) {
+	m.update(ev.Job, func(st *TypeStats) { st.Dropped++ })
+}
+
+func (m *Metrics[T]) update(job T, fn func(st *TypeStats)) {
+	name := m.typeOf(job)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.types[name]
+	if st == nil {
+		st = &TypeStats{
+			Wait:    Histogram{Buckets: m.buckets, Counts: make([]uint64, len(m.buckets)+1)},
+			Latency: Histogram{Buckets: m.buckets, Counts: make([]uint64, len(m.buckets)+1)},
+		}
+		m.types[name] = st
+	}
+	fn(st)
+}
@@ -0,0 +1,82 @@
+package instrument
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WritePrometheus writes the metrics in the Prometheus text exposition
+// format:
+//
+//	<namespace>_jobs_total{type="...",event="enqueued|started|..."}
+//	<namespace>_job_wait_seconds{type="..."}      histogram
+//	<namespace>_job_duration_seconds{type="..."}  histogram
+func (m *Metrics[T]) WritePrometheus(w io.Writer) error {
+	snap := m.Snapshot()
+	names := make([]string, 0, len(snap))
+	for name := range snap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	ns := m.namespace
+	fmt.Fprintf(bw, "# HELP %s_jobs_total Number of job events by job type.\n", ns)
+	fmt.Fprintf(bw, "# TYPE %s_jobs_total counter\n", ns)
+	for _, name := range names {
+		c := snap[name].Counts
+		for _, e := range []struct {
+			event string
+			n     uint64
+		}{
+			{"enqueued", c.Enqueued},
+			{"started", c.Started},
+			{"retried", c.Retried},
+			{"finished", c.Finished},
+			{"failed", c.Failed},
+			{"dropped", c.Dropped},
+		} {
+			fmt.Fprintf(bw, "%s_jobs_total{type=%s,event=%q} %d\n", ns, quoteLabel(name), e.event, e.n)
+		}
+	}
+	writeHistogram(bw, ns+"_job_wait_seconds", "Time jobs waited in the queue.", names, func(name string) Histogram {
+		return snap[name].Wait
+	})
+	writeHistogram(bw, ns+"_job_duration_seconds", "Job processing time, retries included.", names, func(name string) Histogram {
+		return snap[name].Latency
+	})
+	return bw.Flush()
+}
+
+func writeHistogram(w io.Writer, metric, help string, names []string, get func(name string) Histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n", metric, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", metric)
+	for _, name := range names {
+		h := get(name)
+		label := quoteLabel(name)
+		for i, le := range h.Buckets {
+			fmt.Fprintf(w, "%s_bucket{type=%s,le=\"%s\"} %d\n", metric, label, strconv.FormatFloat(le, 'g', -1, 64), h.Counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{type=%s,le=\"+Inf\"} %d\n", metric, label, h.Count())
+		fmt.Fprintf(w, "%s_sum{type=%s} %s\n", metric, label, strconv.FormatFloat(h.Sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{type=%s} %d\n", metric, label, h.Count())
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format, mount it on
+// /metrics.
+func (m *Metrics[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
@@ -0,0 +1,114 @@
+package instrument
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/r3code/go-useful-snippets/channels/pool"
+)
+
+// TracerConfig holds the Tracer settings.
+type TracerConfig[T any] struct {
+	// Tracer creates the spans, e.g. otel.Tracer("jobs").
+	Tracer trace.Tracer
+	// SpanName returns the span name of a job, "pool.job" by default.
+	SpanName func(job T) string
+	// Attributes returns extra span attributes of a job.
+	Attributes func(job T) []attribute.KeyValue
+	// Parent returns the span context of the producer carried by the job,
+	// e.g. saved with trace.SpanContextFromContext before Submit. The queue
+	// keeps only the job, so this is how the job span becomes a child of
+	// the Submit span; when nil or invalid the parent is the span of the
+	// pool context.
+	Parent func(job T) trace.SpanContext
+}
+
+// Tracer is a pool.Hooks running every job in a span. The span is a child
+// of the producer span given by TracerConfig.Parent, or else of the span in
+// the pool context, it is passed to the Handler; retries are span events, a
+// failed job gets an error status.
+type Tracer[T any] struct {
+	tracer     trace.Tracer
+	spanName   func(job T) string
+	attributes func(job T) []attribute.KeyValue
+	parent     func(job T) trace.SpanContext
+}
+
+var _ pool.Hooks[int] = (*Tracer[int])(nil)
+
+type spanKey struct{}
+
+// NewTracer creates a tracing hook.
+func NewTracer[T any](cfg TracerConfig[T]) *Tracer[T] {
+	if cfg.SpanName == nil {
+		cfg.SpanName = func(T) string { return "pool.job" }
+	}
+	return &Tracer[T]{tracer: cfg.Tracer, spanName: cfg.SpanName, attributes: cfg.Attributes, parent: cfg.Parent}
+}
+
+// Enqueued implements pool.Hooks, it adds an event to the span of the
+// producer if there is one in ctx.
+func (t *Tracer[T]) Enqueued(ctx context.Context, _ pool.Event[T]) {
+	trace.SpanFromContext(ctx).AddEvent("pool.enqueued")
+}
+
+// Started implements pool.Hooks.
+func (t *Tracer[T]) Started(ctx context.Context, ev pool.Event[T]) context.Context {
+	attrs := []attribute.KeyValue{attribute.Int("pool.worker_id", ev.WorkerID)}
+	if ev.Wait >= 0 {
+		attrs = append(attrs, attribute.Float64("pool.wait_seconds", ev.Wait.Seconds()))
+	}
+	if t.attributes != nil {
+		attrs = append(attrs, t.attributes(ev.Job)...)
+	}
+	if t.parent != nil {
+		if sc := t.parent(ev.Job); sc.IsValid() {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+		}
+	}
+	ctx, span := t.tracer.Start(ctx, t.spanName(ev.Job),
+		trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attrs...))
+	// keep our span: other hooks may put theirs into the context later
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// Retried implements pool.Hooks.
+func (t *Tracer[T]) Retried(ctx context.Context, ev pool.Event[T]) {
+	if span, ok := ctx.Value(spanKey{}).(trace.Span); ok {
+		span.AddEvent("pool.retry", trace.WithAttributes(
+			attribute.Int("pool.attempt", ev.Attempt),
+			attribute.String("error", ev.Err.Error()),
+		))
+	}
+}
+
+// Finished implements pool.Hooks.
+func (t *Tracer[T]) Finished(ctx context.Context, ev pool.Event[T]) {
+	t.end(ctx, ev)
+}
+
+// Failed implements pool.Hooks.
+func (t *Tracer[T]) Failed(ctx context.Context, ev pool.Event[T]) {
+	t.end(ctx, ev)
+}
+
+// Dropped implements pool.Hooks, it ends the span of a started job.
+func (t *Tracer[T]) Dropped(ctx context.Context, ev pool.Event[T]) {
+	t.end(ctx, ev)
+}
+
+func (t *Tracer[T]) end(ctx context.Context, ev pool.Event[T]) {
+	span, ok := ctx.Value(spanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	span.SetAttributes(attribute.Int("pool.attempts", ev.Attempt))
+	if ev.Err != nil {
+		span.RecordError(ev.Err)
+		span.SetStatus(codes.Error, ev.Err.Error())
+	}
+	span.End()
+}
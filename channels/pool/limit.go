@@ -0,0 +1,220 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var errAborted = errors.New("pool: aborted")
+
+// tokenBucket limits the rate of job starts. A nil bucket does not limit.
+type tokenBucket struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes a token, sleeping until one is available. Tokens are reserved
+// in the order of the calls; a cancelled wait gives its token back.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// keyLimiter bounds the number of in-flight jobs per key and the number of
+// parked jobs. A nil limiter does not limit.
+type keyLimiter[T any] struct {
+	keyOf     func(job T) string
+	max       int
+	maxParked int
+
+	mu      sync.Mutex
+	keys    map[string]*keyState[T]
+	parked  int
+	room    chan struct{} // closed and replaced when a parked job leaves
+	aborted bool
+}
+
+type keyState[T any] struct {
+	inflight int
+	parked   []parkedJob[T]
+}
+
+type parkedJob[T any] struct {
+	job      T
+	enqueued time.Time
+}
+
+func newKeyLimiter[T any](keyOf func(job T) string, max, maxParked int) *keyLimiter[T] {
+	if keyOf == nil {
+		return nil
+	}
+	if max <= 0 {
+		max = 1
+	}
+	if maxParked <= 0 {
+		maxParked = 1
+	}
+	return &keyLimiter[T]{
+		keyOf:     keyOf,
+		max:       max,
+		maxParked: maxParked,
+		keys:      map[string]*keyState[T]{},
+		room:      make(chan struct{}),
+	}
+}
+
+// acquire takes a slot for the job's key. When the key is saturated the job
+// is parked, with its enqueue time, and acquire returns false. While
+// maxParked jobs are parked it waits for room, so the queue fills up and
+// producers feel the backpressure; the job is neither acquired nor parked
+// when ctx is done or the limiter is aborted first.
+func (k *keyLimiter[T]) acquire(ctx context.Context, job T, enqueued time.Time) (bool, error) {
+	if k == nil {
+		return true, nil
+	}
+	key := k.keyOf(job)
+	for {
+		k.mu.Lock()
+		if k.aborted {
+			k.mu.Unlock()
+			return false, errAborted
+		}
+		st := k.keys[key]
+		if st == nil {
+			st = &keyState[T]{}
+			k.keys[key] = st
+		}
+		if st.inflight < k.max {
+			st.inflight++
+			k.mu.Unlock()
+			return true, nil
+		}
+		if k.parked < k.maxParked {
+			st.parked = append(st.parked, parkedJob[T]{job, enqueued})
+			k.parked++
+			k.mu.Unlock()
+			return false, nil
+		}
+		room := k.room
+		k.mu.Unlock()
+		select {
+		case <-room:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// abort wakes and fails the workers waiting in acquire.
+func (k *keyLimiter[T]) abort() {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.aborted = true
+	k.notify()
+}
+
+// notify wakes the workers waiting for room. mu must be held.
+func (k *keyLimiter[T]) notify() {
+	close(k.room)
+	k.room = make(chan struct{})
+}
+
+// release frees the slot of a finished job. If a job with the same key is
+// parked, the slot passes to it and it is returned to be run next.
+func (k *keyLimiter[T]) release(job T) (next T, enqueued time.Time, ok bool) {
+	if k == nil {
+		return next, enqueued, false
+	}
+	key := k.keyOf(job)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	st := k.keys[key]
+	if st == nil {
+		return next, enqueued, false
+	}
+	if len(st.parked) > 0 {
+		pj := st.parked[0]
+		st.parked[0] = parkedJob[T]{}
+		st.parked = st.parked[1:]
+		k.parked--
+		k.notify()
+		return pj.job, pj.enqueued, true
+	}
+	st.inflight--
+	if st.inflight <= 0 {
+		delete(k.keys, key)
+	}
+	return next, enqueued, false
+}
+
+// drain removes and returns all parked jobs.
+func (k *keyLimiter[T]) drain() []T {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var out []T
+	for _, st := range k.keys {
+		for _, pj := range st.parked {
+			out = append(out, pj.job)
+		}
+		st.parked = nil
+	}
+	k.parked = 0
+	k.notify()
+	return out
+}
+
+func (k *keyLimiter[T]) parkedLen() int {
+	if k == nil {
+		return 0
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.parked
+}
@@ -0,0 +1,205 @@
+package pool_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/pool"
+	"github.com/r3code/go-useful-snippets/channels/queue"
+)
+
+func TestPool_RateLimit(t *testing.T) {
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		return nil
+	}, pool.Config[int]{Workers: 4, QueueSize: 20, RateLimit: 100})
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		_ = p.Submit(context.Background(), i)
+	}
+	p.Stop()
+	p.Wait()
+	// the first job takes the initial token, 10 more need 100ms at 100/s
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("11 jobs at 100/s must take ~100ms, took %v", elapsed)
+	}
+}
+
+type keyed struct {
+	customer string
+	id       int
+}
+
+func TestPool_MaxPerKey(t *testing.T) {
+	var mu sync.Mutex
+	inflight := map[string]int{}
+	maxSeen := map[string]int{}
+	var order []keyed
+	p := pool.New(context.Background(), func(ctx context.Context, j keyed) error {
+		mu.Lock()
+		inflight[j.customer]++
+		if inflight[j.customer] > maxSeen[j.customer] {
+			maxSeen[j.customer] = inflight[j.customer]
+		}
+		order = append(order, j)
+		mu.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		mu.Lock()
+		inflight[j.customer]--
+		mu.Unlock()
+		return nil
+	}, pool.Config[keyed]{
+		Workers:   4,
+		QueueSize: 50,
+		KeyOf:     func(j keyed) string { return j.customer },
+		MaxPerKey: 1,
+	})
+	for i := 0; i < 10; i++ {
+		_ = p.Submit(context.Background(), keyed{"big", i})
+	}
+	for i := 0; i < 3; i++ {
+		_ = p.Submit(context.Background(), keyed{"small", i})
+	}
+	p.Stop()
+	p.Wait()
+
+	if maxSeen["big"] != 1 || maxSeen["small"] != 1 {
+		t.Errorf("want at most 1 job per customer in flight, have %v", maxSeen)
+	}
+	if len(order) != 13 {
+		t.Fatalf("want all 13 jobs processed, have %d", len(order))
+	}
+	// jobs of one key keep their submission order
+	last := -1
+	for _, j := range order {
+		if j.customer == "big" {
+			if j.id < last {
+				t.Errorf("big jobs out of order: %v", order)
+				break
+			}
+			last = j.id
+		}
+	}
+	// "small" jobs must not wait behind the whole "big" backlog
+	smallDone := 0
+	for _, j := range order[:8] {
+		if j.customer == "small" {
+			smallDone++
+		}
+	}
+	if smallDone != 3 {
+		t.Errorf("small customer was blocked by the big one: %v", order)
+	}
+}
+
+func TestPool_ShutdownReturnsParkedJobs(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p := pool.New(context.Background(), func(ctx context.Context, j keyed) error {
+		if j.id == 0 {
+			close(started)
+			<-release
+		}
+		return nil
+	}, pool.Config[keyed]{Workers: 2, QueueSize: 10, KeyOf: func(j keyed) string { return j.customer }})
+	_ = p.Submit(context.Background(), keyed{"a", 0})
+	<-started
+	_ = p.Submit(context.Background(), keyed{"a", 1})
+	_ = p.Submit(context.Background(), keyed{"a", 2})
+	waitFor(t, "jobs to be parked", func() bool { return p.Stats().Parked == 2 })
+
+	done := make(chan []keyed)
+	go func() {
+		left, _ := p.Shutdown(context.Background(), pool.Abort)
+		done <- left
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if left := <-done; len(left) != 2 {
+		t.Errorf("want 2 parked jobs returned, have %v", left)
+	}
+}
+
+// A hot key must not drain the bounded queue into the parked jobs: once
+// MaxParked jobs wait, the queue fills and TrySubmit reports ErrFull.
+func TestPool_ParkedJobsBackpressure(t *testing.T) {
+	started := make(chan struct{}, 100)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	done := 0
+	p := pool.New(context.Background(), func(ctx context.Context, j keyed) error {
+		started <- struct{}{}
+		<-release
+		mu.Lock()
+		done++
+		mu.Unlock()
+		return nil
+	}, pool.Config[keyed]{Workers: 2, QueueSize: 2, KeyOf: func(j keyed) string { return j.customer }})
+	_ = p.Submit(context.Background(), keyed{"hot", 0})
+	<-started
+
+	accepted := 1
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := p.TrySubmit(keyed{"hot", accepted})
+		if err == queue.ErrFull {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted++
+		if time.Now().After(deadline) {
+			t.Fatalf("TrySubmit accepted %d jobs of one key without ErrFull", accepted)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// running + MaxParked + one dequeued by the waiting worker + QueueSize
+	if max := 1 + 2 + 1 + 2; accepted > max {
+		t.Errorf("accepted %d jobs, want at most %d", accepted, max)
+	}
+	if n := p.Stats().Parked; n > 2 {
+		t.Errorf("want at most 2 parked jobs, have %d", n)
+	}
+
+	close(release)
+	p.Stop()
+	p.Wait()
+	if done != accepted {
+		t.Errorf("want all %d accepted jobs processed, have %d", accepted, done)
+	}
+}
+
+// Abort must not hang on a worker waiting for room to park a job.
+func TestPool_AbortWakesWorkerWaitingToPark(t *testing.T) {
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	p := pool.New(context.Background(), func(ctx context.Context, j keyed) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}, pool.Config[keyed]{Workers: 2, QueueSize: 1, KeyOf: func(j keyed) string { return j.customer }})
+	_ = p.Submit(context.Background(), keyed{"hot", 0})
+	<-started
+	_ = p.Submit(context.Background(), keyed{"hot", 1}) // parked
+	waitFor(t, "a job to be parked", func() bool { return p.Stats().Parked == 1 })
+	_ = p.Submit(context.Background(), keyed{"hot", 2}) // taken by the other worker, waits for room
+	waitFor(t, "the queue to be drained", func() bool { return p.Stats().Queued == 0 })
+
+	done := make(chan []keyed)
+	go func() {
+		left, _ := p.Shutdown(context.Background(), pool.Abort)
+		done <- left
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	select {
+	case left := <-done:
+		if len(left) != 2 {
+			t.Errorf("want 2 unprocessed jobs, have %v", left)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown(Abort) hangs")
+	}
+}
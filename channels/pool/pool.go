@@ -0,0 +1,439 @@
+// Package pool provides a generic worker pool: goroutines processing jobs
+// taken from a queue, with context cancellation and graceful stop. It is the
+// importable version of the worker snippets in channels/.
+//
+// Example:
+//
+//	p := pool.New(ctx, func(ctx context.Context, job Job) error {
+//		return process(ctx, job)
+//	}, pool.Config[Job]{Workers: 8, QueueSize: 100})
+//
+//	// in an HTTP handler
+//	if err := p.TrySubmit(job); err != nil {
+//		http.Error(w, "max capacity reached", 503)
+//		return
+//	}
+//
+//	// on shutdown: stop accepting jobs, let the workers finish the queued ones
+//	p.Stop()
+//	if err := p.WaitContext(shutdownCtx); err != nil {
+//		log.Println("workers did not stop in time")
+//	}
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/queue"
+	"github.com/r3code/go-useful-snippets/channels/wait"
+)
+
+// dequeueRetryDelay is the pause of a worker after a failed dequeue.
+const dequeueRetryDelay = 10 * time.Millisecond
+
+// Handler processes a single job. ctx is cancelled when the pool's context is.
+type Handler[T any] func(ctx context.Context, job T) error
+
+// Config holds the pool settings. The zero value is usable.
+type Config[T any] struct {
+	// Workers is the number of worker goroutines, runtime.NumCPU() by default.
+	// With autoscaling it is the minimal number of workers.
+	Workers int
+	// MaxWorkers enables autoscaling when greater than Workers: the pool adds
+	// workers, up to MaxWorkers, while jobs are waiting in the queue.
+	MaxWorkers int
+	// IdleTimeout is how long a worker above Workers waits for a job before
+	// exiting, 30 seconds by default. Used only with autoscaling.
+	IdleTimeout time.Duration
+	// TargetWait is the acceptable time a job waits in the queue. When set,
+	// the pool grows only if the estimated wait (queued jobs * average job
+	// duration / workers) exceeds it; otherwise the pool grows whenever all
+	// workers are busy and jobs are queued. Used only with autoscaling.
+	TargetWait time.Duration
+	// QueueSize is the capacity of the default queue, equal to MaxWorkers
+	// (or Workers) by default.
+	QueueSize int
+	// Queue replaces the default in-memory queue, QueueSize is ignored then.
+	// When it implements queue.Acker, a job is acked once it succeeded or
+	// finally failed, so a failing job is not delivered again and again; it
+	// is nacked only when left unprocessed by Shutdown or cancellation.
+	// A failed Ack or Nack goes to OnError.
+	Queue queue.Queue[T]
+	// OnError is called with every job that finally failed: its Handler
+	// returned an error on the last attempt or a non-retryable one. It also
+	// gets the errors of the queue, with the zero job when the queue did not
+	// return one.
+	OnError func(job T, err error)
+	// Retry is the retry policy for failed jobs, no retries by default.
+	Retry RetryPolicy
+	// RetryFor overrides Retry per job, e.g. by job type.
+	RetryFor func(job T) RetryPolicy
+	// DeadLetter receives jobs that finally failed. Set it with an acking
+	// queue to keep such jobs: they are acked whether the sink took them or
+	// not, a sink error goes to OnError.
+	DeadLetter DeadLetterSink[T]
+	// Supervision configures how panics in Handler are handled.
+	Supervision Supervision
+	// OnPanic is called for every recovered panic, see LogPanics.
+	OnPanic func(ev PanicEvent[T])
+	// RateLimit is the maximum number of jobs started per second by the
+	// whole pool (token bucket). Zero means unlimited.
+	RateLimit float64
+	// RateBurst is the token bucket size, 1 by default.
+	RateBurst int
+	// KeyOf returns the concurrency key of a job, e.g. a customer id.
+	// Jobs whose key already has MaxPerKey jobs in flight are set aside
+	// and run as soon as a job with the same key finishes, so they do not
+	// block jobs with other keys.
+	KeyOf func(job T) string
+	// MaxPerKey is the number of jobs with the same key allowed in flight,
+	// 1 by default. Used only with KeyOf.
+	MaxPerKey int
+	// MaxParked bounds the jobs set aside by KeyOf, QueueSize by default.
+	// When it is reached workers stop taking jobs from the queue until a
+	// parked job runs, so a hot key fills the queue and Submit blocks or
+	// TrySubmit returns queue.ErrFull. Used only with KeyOf.
+	MaxParked int
+	// Hooks observes enqueued, started, retried, finished, failed and
+	// dropped jobs, see MultiHooks to combine several.
+	Hooks Hooks[T]
+}
+
+// Pool runs Handler for every submitted job on a set of workers.
+type Pool[T any] struct {
+	handler    Handler[T]
+	onError    func(job T, err error)
+	queue      queue.Queue[T]
+	retry      RetryPolicy
+	retryFor   func(job T) RetryPolicy
+	deadLetter DeadLetterSink[T]
+	onPanic    func(ev PanicEvent[T])
+	super      *supervisor
+	limiter    *tokenBucket
+	keys       *keyLimiter[T]
+	hooks      Hooks[T]
+
+	minWorkers  int
+	maxWorkers  int
+	idleTimeout time.Duration
+	targetWait  time.Duration
+	size        int64 // current number of workers
+	lastID      int64 // id of the last spawned worker
+	busy        int64 // workers running a job
+	latency     latencyMeter
+
+	ctx        context.Context
+	cancel     context.CancelFunc
+	stopWatch  func() bool
+	wg         sync.WaitGroup
+	scaleMu    sync.Mutex
+	stopped    bool
+	closeQueue sync.Once
+
+	aborting  int32 // set by Shutdown(Abort)
+	leftMu    sync.Mutex
+	leftovers []T // jobs not processed because of Abort or cancellation
+	unsettled []T // leftovers to nack on an acking queue
+}
+
+// New starts the workers. They run until Stop is called and the queue is
+// drained, or until ctx is cancelled; in the latter case jobs still in the
+// queue are abandoned and the job in progress sees a cancelled context.
+func New[T any](ctx context.Context, h Handler[T], cfg Config[T]) *Pool[T] {
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.NumCPU()
+	}
+	if cfg.MaxWorkers < cfg.Workers {
+		cfg.MaxWorkers = cfg.Workers
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 30 * time.Second
+	}
+	if cfg.Hooks == nil {
+		cfg.Hooks = NopHooks[T]{}
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = cfg.MaxWorkers
+	}
+	if cfg.Queue == nil {
+		cfg.Queue = queue.NewBounded[T](cfg.QueueSize)
+	}
+	if cfg.MaxParked <= 0 {
+		cfg.MaxParked = cfg.QueueSize
+	}
+	p := &Pool[T]{
+		handler:     h,
+		onError:     cfg.OnError,
+		queue:       cfg.Queue,
+		retry:       cfg.Retry,
+		retryFor:    cfg.RetryFor,
+		deadLetter:  cfg.DeadLetter,
+		onPanic:     cfg.OnPanic,
+		super:       newSupervisor(cfg.Supervision),
+		limiter:     newTokenBucket(cfg.RateLimit, cfg.RateBurst),
+		keys:        newKeyLimiter(cfg.KeyOf, cfg.MaxPerKey, cfg.MaxParked),
+		hooks:       cfg.Hooks,
+		minWorkers:  cfg.Workers,
+		maxWorkers:  cfg.MaxWorkers,
+		idleTimeout: cfg.IdleTimeout,
+		targetWait:  cfg.TargetWait,
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	// a cancelled pool must reject new jobs instead of queueing them forever
+	p.stopWatch = context.AfterFunc(p.ctx, p.closeQ)
+
+	p.scaleMu.Lock()
+	for i := 0; i < cfg.Workers; i++ {
+		p.spawn()
+	}
+	p.scaleMu.Unlock()
+	return p
+}
+
+// Submit queues the job, blocking while the queue is full.
+// Returns queue.ErrClosed after Stop or pool cancellation, ErrCircuitOpen
+// while the panic circuit is open, or ctx.Err().
+func (p *Pool[T]) Submit(ctx context.Context, job T) error {
+	return p.submitted(ctx, job, p.submit(ctx, job))
+}
+
+func (p *Pool[T]) submit(ctx context.Context, job T) error {
+	if p.ctx.Err() != nil {
+		return queue.ErrClosed
+	}
+	if p.super.isOpen() {
+		return ErrCircuitOpen
+	}
+	return p.queue.Enqueue(ctx, job)
+}
+
+// TrySubmit queues the job without blocking.
+// Returns queue.ErrFull when the queue has no room, queue.ErrClosed after
+// Stop, ErrCircuitOpen while the panic circuit is open.
+func (p *Pool[T]) TrySubmit(job T) error {
+	return p.submitted(context.Background(), job, p.trySubmit(job))
+}
+
+func (p *Pool[T]) trySubmit(job T) error {
+	if p.ctx.Err() != nil {
+		return queue.ErrClosed
+	}
+	if p.super.isOpen() {
+		return ErrCircuitOpen
+	}
+	return p.queue.TryEnqueue(job)
+}
+
+// submitted reports the outcome of a submit to the hooks.
+func (p *Pool[T]) submitted(ctx context.Context, job T, err error) error {
+	if err != nil {
+		p.hooks.Dropped(ctx, Event[T]{Job: job, Wait: -1, Err: err})
+		return err
+	}
+	p.hooks.Enqueued(ctx, Event[T]{Job: job, Wait: -1})
+	p.maybeGrow()
+	return nil
+}
+
+// Stop makes the pool reject new jobs. Workers finish the jobs already
+// queued and exit; use Wait to block until they are done.
+func (p *Pool[T]) Stop() {
+	p.closeQ()
+}
+
+// Wait blocks until all workers have exited, i.e. after Stop and the queue
+// is drained, or after the pool context is cancelled.
+func (p *Pool[T]) Wait() {
+	p.wg.Wait()
+	p.release()
+	p.nackLeft()
+}
+
+// WaitContext is Wait bounded by ctx. When ctx is done first it cancels the
+// pool context, so jobs in progress are asked to stop, and returns ctx.Err().
+func (p *Pool[T]) WaitContext(ctx context.Context) error {
+	err := wait.WaitContext(ctx, &p.wg)
+	p.release()
+	p.nackLeft()
+	return err
+}
+
+// Workers returns the current number of worker goroutines.
+func (p *Pool[T]) Workers() int {
+	return int(atomic.LoadInt64(&p.size))
+}
+
+// Len returns the number of jobs waiting in the queue.
+func (p *Pool[T]) Len() int {
+	return p.queue.Len()
+}
+
+func (p *Pool[T]) release() {
+	p.stopWatch()
+	p.cancel()
+}
+
+func (p *Pool[T]) closeQ() {
+	p.closeQueue.Do(func() {
+		p.scaleMu.Lock()
+		p.stopped = true
+		p.scaleMu.Unlock()
+		p.queue.Close()
+	})
+}
+
+func (p *Pool[T]) worker(w *workerState[T]) {
+	defer p.wg.Done()
+	for {
+		if !p.super.waitClosed(p.ctx) {
+			p.dropHandoff(w)
+			atomic.AddInt64(&p.size, -1)
+			return
+		}
+		job, enqueued, err := p.take(w)
+		if err != nil {
+			return
+		}
+		if p.ctx.Err() != nil || atomic.LoadInt32(&p.aborting) == 1 || p.limiter.wait(p.ctx) != nil {
+			p.leave(context.Background(), unstarted(job), true)
+			p.dropHandoff(w)
+			atomic.AddInt64(&p.size, -1)
+			return
+		}
+		p.process(w, job, enqueued)
+		if next, at, ok := p.keys.release(job); ok {
+			w.handoff, w.handoffAt, w.hasHandoff = next, at, true
+		}
+		if p.ctx.Err() != nil || (w.panicked && !p.restart(w)) {
+			p.dropHandoff(w)
+			atomic.AddInt64(&p.size, -1)
+			return
+		}
+	}
+}
+
+// take returns the job handed over by a finished job with the same key, or
+// dequeues jobs until one whose key has a free slot. enqueued is the enqueue
+// time of the job, zero when unknown.
+func (p *Pool[T]) take(w *workerState[T]) (job T, enqueued time.Time, err error) {
+	if w.hasHandoff {
+		job, enqueued = w.handoff, w.handoffAt
+		var zero T
+		w.handoff, w.handoffAt, w.hasHandoff = zero, time.Time{}, false
+		return job, enqueued, nil
+	}
+	for {
+		job, enqueued, err = p.next()
+		if err != nil {
+			return job, enqueued, err
+		}
+		ok, werr := p.keys.acquire(p.ctx, job, enqueued)
+		if ok || werr != nil {
+			// a job neither acquired nor parked means the pool is stopping,
+			// the caller leaves it
+			return job, enqueued, nil
+		}
+	}
+}
+
+func (p *Pool[T]) dropHandoff(w *workerState[T]) {
+	if w.hasHandoff {
+		p.leave(context.Background(), unstarted(w.handoff), true)
+		var zero T
+		w.handoff, w.handoffAt, w.hasHandoff = zero, time.Time{}, false
+	}
+}
+
+// next returns the next job. A worker above the minimum gives up after
+// IdleTimeout without jobs and leaves the pool (errIdle). Dequeue errors
+// other than a closed queue or pool are reported to OnError and the worker
+// goes on.
+func (p *Pool[T]) next() (T, time.Time, error) {
+	if p.maxWorkers == p.minWorkers {
+		for {
+			job, enqueued, err := p.dequeue(p.ctx)
+			if err != nil && p.transient(err) {
+				continue
+			}
+			if err != nil {
+				atomic.AddInt64(&p.size, -1)
+			}
+			return job, enqueued, err
+		}
+	}
+	for {
+		idleCtx, cancel := context.WithTimeout(p.ctx, p.idleTimeout)
+		job, enqueued, err := p.dequeue(idleCtx)
+		cancel()
+		if err == nil {
+			return job, enqueued, nil
+		}
+		if errors.Is(err, context.DeadlineExceeded) && p.ctx.Err() == nil {
+			if p.shrink() {
+				return job, enqueued, errIdle
+			}
+			continue
+		}
+		if p.transient(err) {
+			continue
+		}
+		atomic.AddInt64(&p.size, -1)
+		return job, enqueued, err
+	}
+}
+
+// transient reports whether a dequeue error leaves the queue usable, e.g. a
+// message the queue could not read. Such an error goes to OnError and the
+// worker pauses for dequeueRetryDelay, so a failing queue is not hammered.
+func (p *Pool[T]) transient(err error) bool {
+	if errors.Is(err, queue.ErrClosed) || p.ctx.Err() != nil {
+		return false
+	}
+	if p.onError != nil {
+		var zero T
+		p.onError(zero, fmt.Errorf("pool: dequeue: %w", err))
+	}
+	timer := time.NewTimer(dequeueRetryDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-p.ctx.Done():
+	}
+	return true
+}
+
+// dequeue takes a job from the queue with its enqueue time when the queue
+// records it.
+func (p *Pool[T]) dequeue(ctx context.Context) (T, time.Time, error) {
+	if timed, ok := p.queue.(queue.Timed[T]); ok {
+		return timed.DequeueTimed(ctx)
+	}
+	job, err := p.queue.Dequeue(ctx)
+	return job, time.Time{}, err
+}
+
+func (p *Pool[T]) process(w *workerState[T], job T, enqueued time.Time) {
+	atomic.AddInt64(&p.busy, 1)
+	// this worker is taken, check whether the rest of the queue needs one more
+	p.maybeGrow()
+	w.panicked = false
+	ev := Event[T]{Job: job, WorkerID: w.id, Attempt: 1, Wait: -1}
+	if !enqueued.IsZero() {
+		ev.Wait = time.Since(enqueued)
+	}
+	ctx := p.hooks.Started(p.ctx, ev)
+	left := p.run(ctx, w, ev)
+	atomic.AddInt64(&p.busy, -1)
+	if acker, ok := p.queue.(queue.Acker[T]); ok && !left {
+		// left jobs are nacked by nackLeft
+		if err := acker.Ack(job); err != nil && p.onError != nil {
+			p.onError(job, fmt.Errorf("pool: acknowledge job: %w", err))
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/pool"
+	"github.com/r3code/go-useful-snippets/channels/queue"
+)
+
+func TestPool_ProcessesAllJobs(t *testing.T) {
+	var sum int64
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		atomic.AddInt64(&sum, int64(n))
+		return nil
+	}, pool.Config[int]{Workers: 4, QueueSize: 10})
+
+	for i := 1; i <= 100; i++ {
+		if err := p.Submit(context.Background(), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Stop()
+	p.Wait()
+
+	if sum != 5050 {
+		t.Errorf("want sum 5050, have %d", sum)
+	}
+	if err := p.Submit(context.Background(), 1); !errors.Is(err, queue.ErrClosed) {
+		t.Errorf("Submit after Stop: want ErrClosed, have %v", err)
+	}
+}
+
+func TestPool_TrySubmitFull(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}, pool.Config[int]{Workers: 1, QueueSize: 1})
+
+	if err := p.TrySubmit(1); err != nil {
+		t.Fatal(err)
+	}
+	<-started // the worker holds job 1, the queue is empty again
+	if err := p.TrySubmit(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.TrySubmit(3); !errors.Is(err, queue.ErrFull) {
+		t.Errorf("want ErrFull, have %v", err)
+	}
+	close(release)
+	p.Stop()
+	p.Wait()
+}
+
+func TestPool_ContextCancelStopsWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var processed int64
+	p := pool.New(ctx, func(ctx context.Context, n int) error {
+		atomic.AddInt64(&processed, 1)
+		<-ctx.Done()
+		return ctx.Err()
+	}, pool.Config[int]{Workers: 2, QueueSize: 10})
+
+	for i := 0; i < 10; i++ {
+		_ = p.Submit(context.Background(), i)
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("workers did not exit after context cancellation")
+	}
+	if n := atomic.LoadInt64(&processed); n > 2 {
+		t.Errorf("queued jobs must be abandoned after cancel, %d processed", n)
+	}
+	if err := p.TrySubmit(1); !errors.Is(err, queue.ErrClosed) {
+		t.Errorf("want ErrClosed after cancel, have %v", err)
+	}
+}
+
+func TestPool_OnError(t *testing.T) {
+	boom := errors.New("boom")
+	var mu sync.Mutex
+	var failed []int
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		if n%2 == 0 {
+			return boom
+		}
+		return nil
+	}, pool.Config[int]{Workers: 1, OnError: func(n int, err error) {
+		mu.Lock()
+		failed = append(failed, n)
+		mu.Unlock()
+	}})
+	for i := 1; i <= 4; i++ {
+		_ = p.Submit(context.Background(), i)
+	}
+	p.Stop()
+	p.Wait()
+	if len(failed) != 2 || failed[0] != 2 || failed[1] != 4 {
+		t.Errorf("want failed jobs [2 4], have %v", failed)
+	}
+}
+
+// brokenQueue fails the first dequeues, like a queue with a record it
+// cannot read.
+type brokenQueue struct {
+	*queue.Bounded[int]
+	failures int32
+}
+
+var errBrokenRecord = errors.New("broken record")
+
+func (q *brokenQueue) Dequeue(ctx context.Context) (int, error) {
+	n, _, err := q.DequeueTimed(ctx)
+	return n, err
+}
+
+func (q *brokenQueue) DequeueTimed(ctx context.Context) (int, time.Time, error) {
+	if atomic.AddInt32(&q.failures, -1) >= 0 {
+		return 0, time.Time{}, errBrokenRecord
+	}
+	return q.Bounded.DequeueTimed(ctx)
+}
+
+func TestPool_DequeueErrorKeepsWorkers(t *testing.T) {
+	q := &brokenQueue{Bounded: queue.NewBounded[int](10), failures: 3}
+	var mu sync.Mutex
+	var reported []error
+	var processed int64
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		atomic.AddInt64(&processed, 1)
+		return nil
+	}, pool.Config[int]{Workers: 1, Queue: q, OnError: func(_ int, err error) {
+		mu.Lock()
+		reported = append(reported, err)
+		mu.Unlock()
+	}})
+	for i := 0; i < 5; i++ {
+		_ = p.Submit(context.Background(), i)
+	}
+	p.Stop()
+	p.Wait()
+	if processed != 5 {
+		t.Errorf("want all 5 jobs processed after dequeue errors, have %d", processed)
+	}
+	if len(reported) != 3 || !errors.Is(reported[0], errBrokenRecord) {
+		t.Errorf("want 3 dequeue errors reported, have %v", reported)
+	}
+}
+
+func TestPool_WaitContextDeadline(t *testing.T) {
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, pool.Config[int]{Workers: 1})
+	_ = p.Submit(context.Background(), 1)
+	p.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.WaitContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want DeadlineExceeded, have %v", err)
+	}
+	// the pool context is cancelled now, so the busy worker exits
+	p.Wait()
+}
+
+func TestPool_PriorityQueue(t *testing.T) {
+	q, err := queue.NewPriority(queue.PriorityConfig[string]{
+		Lanes: []queue.Lane{
+			{Name: "interactive", Weight: 8, Capacity: 10},
+			{Name: "bulk", Weight: 1, Capacity: 10},
+		},
+		LaneOf: func(job string) string { return job },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	done := map[string]int{}
+	p := pool.New(context.Background(), func(ctx context.Context, job string) error {
+		mu.Lock()
+		done[job]++
+		mu.Unlock()
+		return nil
+	}, pool.Config[string]{Workers: 2, Queue: q})
+	for i := 0; i < 5; i++ {
+		_ = p.Submit(context.Background(), "bulk")
+		_ = p.Submit(context.Background(), "interactive")
+	}
+	p.Stop()
+	p.Wait()
+	if done["bulk"] != 5 || done["interactive"] != 5 {
+		t.Errorf("want all jobs processed, have %v", done)
+	}
+}
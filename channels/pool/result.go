@@ -0,0 +1,137 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"runtime/debug"
+)
+
+// ErrSkipped is set on results of jobs that were not run because an earlier
+// job failed in FailFast mode or the context was cancelled.
+var ErrSkipped = errors.New("pool: job skipped")
+
+// Func computes a value for a job.
+type Func[T, R any] func(ctx context.Context, job T) (R, error)
+
+// Result is the outcome of a single job: either Value or Err is meaningful.
+type Result[T, R any] struct {
+	// Index is the position of the job in the input.
+	Index int
+	Job   T
+	Value R
+	Err   error
+}
+
+// RunConfig configures Stream and Map.
+type RunConfig struct {
+	// Workers bounds the number of jobs in flight, runtime.NumCPU() by default.
+	Workers int
+	// FailFast cancels the remaining jobs after the first error, like errgroup.
+	FailFast bool
+}
+
+type indexed[T any] struct {
+	index int
+	job   T
+}
+
+// Stream runs fn for every job read from jobs and delivers the results in
+// completion order. The returned channel is closed after jobs is closed and
+// all started jobs are finished, or early after cancellation. The caller
+// should read it until it is closed; results are dropped only when ctx is
+// cancelled. A panic in fn is recovered, the job's Err is a *PanicError.
+func Stream[T, R any](ctx context.Context, jobs <-chan T, fn Func[T, R], cfg RunConfig) <-chan Result[T, R] {
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.NumCPU()
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	out := make(chan Result[T, R], cfg.Workers)
+
+	p := New(runCtx, func(jobCtx context.Context, it indexed[T]) error {
+		v, err := callFunc(jobCtx, fn, it.job)
+		if err != nil && cfg.FailFast {
+			cancel()
+		}
+		select {
+		case out <- Result[T, R]{Index: it.index, Job: it.job, Value: v, Err: err}:
+		case <-ctx.Done():
+		}
+		return nil
+	}, Config[indexed[T]]{Workers: cfg.Workers})
+
+	go func() {
+		defer close(out)
+		defer cancel()
+		i := 0
+	feed:
+		for {
+			select {
+			case job, ok := <-jobs:
+				if !ok {
+					break feed
+				}
+				if p.Submit(runCtx, indexed[T]{index: i, job: job}) != nil {
+					break feed
+				}
+				i++
+			case <-runCtx.Done():
+				break feed
+			}
+		}
+		p.Stop()
+		p.Wait()
+	}()
+	return out
+}
+
+// callFunc runs fn converting a panic to *PanicError, so that the job gets
+// its Result.
+func callFunc[T, R any](ctx context.Context, fn Func[T, R], job T) (v R, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return fn(ctx, job)
+}
+
+// Map runs fn for all jobs with bounded concurrency and returns the results
+// in input order together with the first error that occurred. Jobs that were
+// not run have Err set to ErrSkipped.
+func Map[T, R any](ctx context.Context, jobs []T, fn Func[T, R], cfg RunConfig) ([]Result[T, R], error) {
+	results := make([]Result[T, R], len(jobs))
+	for i, job := range jobs {
+		results[i] = Result[T, R]{Index: i, Job: job, Err: ErrSkipped}
+	}
+
+	in := make(chan T)
+	feedCtx, stopFeed := context.WithCancel(ctx)
+	defer stopFeed()
+	go func() {
+		defer close(in)
+		for _, job := range jobs {
+			select {
+			case in <- job:
+			case <-feedCtx.Done():
+				return
+			}
+		}
+	}()
+
+	var firstErr error
+	for res := range Stream(ctx, in, fn, cfg) {
+		results[res.Index] = res
+		if res.Err != nil && firstErr == nil {
+			firstErr = res.Err
+			if cfg.FailFast {
+				// Stream stops reading input, release the feeder
+				stopFeed()
+			}
+		}
+	}
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	return results, firstErr
+}
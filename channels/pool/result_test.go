@@ -0,0 +1,127 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"testing"
+
+	"github.com/r3code/go-useful-snippets/channels/pool"
+)
+
+func square(ctx context.Context, n int) (int, error) {
+	return n * n, nil
+}
+
+func TestMap_InputOrder(t *testing.T) {
+	jobs := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	results, err := pool.Map(context.Background(), jobs, square, pool.RunConfig{Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range results {
+		if res.Index != i || res.Job != jobs[i] || res.Value != jobs[i]*jobs[i] || res.Err != nil {
+			t.Errorf("result %d: unexpected %+v", i, res)
+		}
+	}
+}
+
+func TestMap_CollectsErrors(t *testing.T) {
+	boom := errors.New("boom")
+	results, err := pool.Map(context.Background(), []int{1, 2, 3}, func(ctx context.Context, n int) (int, error) {
+		if n == 2 {
+			return 0, boom
+		}
+		return n, nil
+	}, pool.RunConfig{Workers: 2})
+	if !errors.Is(err, boom) {
+		t.Fatalf("want boom, have %v", err)
+	}
+	if results[0].Err != nil || results[1].Err != boom || results[2].Err != nil {
+		t.Errorf("without FailFast every job must run: %+v", results)
+	}
+}
+
+func TestMap_FailFast(t *testing.T) {
+	boom := errors.New("boom")
+	var started int64
+	jobs := make([]int, 100)
+	results, err := pool.Map(context.Background(), jobs, func(ctx context.Context, n int) (int, error) {
+		atomic.AddInt64(&started, 1)
+		return 0, boom
+	}, pool.RunConfig{Workers: 1, FailFast: true})
+	if !errors.Is(err, boom) {
+		t.Fatalf("want boom, have %v", err)
+	}
+	if n := atomic.LoadInt64(&started); n >= int64(len(jobs)) {
+		t.Errorf("FailFast must skip remaining jobs, %d started", n)
+	}
+	skipped := 0
+	for _, res := range results {
+		if errors.Is(res.Err, pool.ErrSkipped) {
+			skipped++
+		}
+	}
+	if skipped == 0 {
+		t.Errorf("want skipped results")
+	}
+}
+
+func TestStream_CompletionOrder(t *testing.T) {
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 1; i <= 20; i++ {
+			in <- i
+		}
+	}()
+	var have []int
+	for res := range pool.Stream(context.Background(), in, square, pool.RunConfig{Workers: 4}) {
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		have = append(have, res.Value)
+	}
+	sort.Ints(have)
+	if len(have) != 20 || have[0] != 1 || have[19] != 400 {
+		t.Errorf("unexpected results %v", have)
+	}
+}
+
+func TestStream_NegativeWorkers(t *testing.T) {
+	in := make(chan int, 3)
+	in <- 1
+	in <- 2
+	in <- 3
+	close(in)
+	n := 0
+	for res := range pool.Stream(context.Background(), in, square, pool.RunConfig{Workers: -1}) {
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("want 3 results, have %d", n)
+	}
+}
+
+func TestMap_PanicIsReported(t *testing.T) {
+	results, err := pool.Map(context.Background(), []int{1, 2, 3}, func(ctx context.Context, n int) (int, error) {
+		if n == 2 {
+			panic("boom")
+		}
+		return n, nil
+	}, pool.RunConfig{Workers: 2})
+	var perr *pool.PanicError
+	if !errors.As(err, &perr) || perr.Value != "boom" {
+		t.Fatalf("want the panic as error, have %v", err)
+	}
+	if !errors.As(results[1].Err, &perr) {
+		t.Errorf("want a *PanicError for the panicking job, have %v", results[1].Err)
+	}
+	if results[0].Value != 1 || results[2].Value != 3 {
+		t.Errorf("other jobs must succeed, have %+v", results)
+	}
+}
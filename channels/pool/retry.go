@@ -0,0 +1,156 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy describes how a failed job is retried. The zero value runs
+// every job once.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one.
+	MaxAttempts int
+	// InitialBackoff is the pause before the second attempt, 100ms by default.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the pause, 30 seconds by default.
+	MaxBackoff time.Duration
+	// Multiplier grows the pause after every attempt, 2 by default.
+	Multiplier float64
+	// Jitter randomizes the pause by up to this fraction in both directions,
+	// e.g. 0.2 gives 80%..120% of the computed backoff.
+	Jitter float64
+	// Retryable tells whether an error is worth another attempt. By default
+	// every error is, except the ones wrapped with Permanent and panics.
+	Retryable func(err error) bool
+}
+
+// Backoff returns the pause after the given failed attempt (1-based).
+func (r RetryPolicy) Backoff(attempt int) time.Duration {
+	initial, max, mult := r.InitialBackoff, r.MaxBackoff, r.Multiplier
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	if mult < 1 {
+		mult = 2
+	}
+	d := float64(initial) * math.Pow(mult, float64(attempt-1))
+	if d > float64(max) {
+		d = float64(max)
+	}
+	if r.Jitter > 0 {
+		d *= 1 + r.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+func (r RetryPolicy) retryable(err error) bool {
+	if IsPermanent(err) {
+		return false
+	}
+	if r.Retryable != nil {
+		return r.Retryable(err)
+	}
+	var perr *PanicError
+	return !errors.As(err, &perr)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not retryable: the job goes to the dead letter
+// sink right away.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+type attemptKey struct{}
+
+// Attempt returns the current attempt number (1-based) inside a Handler.
+func Attempt(ctx context.Context) int {
+	if n, ok := ctx.Value(attemptKey{}).(int); ok {
+		return n
+	}
+	return 1
+}
+
+// run executes the job with retries. Returns true when the job was left
+// unprocessed because the pool is shutting down; otherwise it succeeded or
+// finally failed. ctx comes from Hooks.Started, ev is the start event.
+func (p *Pool[T]) run(ctx context.Context, w *workerState[T], ev Event[T]) (left bool) {
+	job := ev.Job
+	policy := p.retry
+	if p.retryFor != nil {
+		policy = p.retryFor(job)
+	}
+	begin := time.Now()
+	for attempt := 1; ; attempt++ {
+		ev.Attempt = attempt
+		start := time.Now()
+		err := p.call(context.WithValue(ctx, attemptKey{}, attempt), w, job)
+		p.latency.observe(time.Since(start))
+		if err == nil {
+			ev.Duration = time.Since(begin)
+			p.hooks.Finished(ctx, ev)
+			return false
+		}
+		if p.ctx.Err() != nil {
+			// shutting down: the job is unprocessed, no dead letter
+			ev.Duration = time.Since(begin)
+			p.leave(ctx, ev, true)
+			return true
+		}
+		if attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			ev.Duration, ev.Err = time.Since(begin), err
+			p.fail(ctx, ev)
+			return false
+		}
+		retry := ev
+		retry.Duration, retry.Err = time.Since(start), err
+		p.hooks.Retried(ctx, retry)
+		timer := time.NewTimer(policy.Backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-p.ctx.Done():
+			timer.Stop()
+			ev.Duration = time.Since(begin)
+			p.leave(ctx, ev, true)
+			return true
+		}
+	}
+}
+
+// fail reports a finally failed job and passes it to the dead letter sink.
+func (p *Pool[T]) fail(ctx context.Context, ev Event[T]) {
+	job, err, attempts := ev.Job, ev.Err, ev.Attempt
+	if p.onError != nil {
+		p.onError(job, err)
+	}
+	p.hooks.Failed(ctx, ev)
+	if p.deadLetter == nil {
+		return
+	}
+	dl := DeadLetter[T]{Job: job, Err: err, Attempts: attempts, FailedAt: time.Now()}
+	if dlErr := p.deadLetter.Put(p.ctx, dl); dlErr != nil && p.onError != nil {
+		p.onError(job, fmt.Errorf("pool: dead letter: %w", dlErr))
+	}
+}
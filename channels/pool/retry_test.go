@@ -0,0 +1,202 @@
+package pool_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/diskqueue"
+	"github.com/r3code/go-useful-snippets/channels/pool"
+)
+
+var fastRetry = pool.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+func TestPool_RetryUntilSuccess(t *testing.T) {
+	var mu sync.Mutex
+	var attempts []int
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, pool.Attempt(ctx))
+		if len(attempts) < 3 {
+			return errors.New("temporary")
+		}
+		return nil
+	}, pool.Config[int]{Workers: 1, Retry: fastRetry, OnError: func(int, error) {
+		t.Error("OnError must not be called for a job that succeeded on retry")
+	}})
+	_ = p.Submit(context.Background(), 1)
+	p.Stop()
+	p.Wait()
+	if len(attempts) != 3 || attempts[0] != 1 || attempts[2] != 3 {
+		t.Errorf("want attempts [1 2 3], have %v", attempts)
+	}
+}
+
+func TestPool_DeadLetterAfterRetries(t *testing.T) {
+	dead := make(chan pool.DeadLetter[int], 2)
+	boom := errors.New("boom")
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		if n == 2 {
+			return pool.Permanent(boom)
+		}
+		return boom
+	}, pool.Config[int]{
+		Workers:    1,
+		Retry:      fastRetry,
+		DeadLetter: pool.DeadLetterChan[int](dead),
+	})
+	_ = p.Submit(context.Background(), 1)
+	_ = p.Submit(context.Background(), 2)
+	p.Stop()
+	p.Wait()
+	close(dead)
+
+	var got []pool.DeadLetter[int]
+	for dl := range dead {
+		got = append(got, dl)
+	}
+	if len(got) != 2 {
+		t.Fatalf("want 2 dead letters, have %d", len(got))
+	}
+	if got[0].Job != 1 || got[0].Attempts != 3 || !errors.Is(got[0].Err, boom) {
+		t.Errorf("retryable job must exhaust attempts: %+v", got[0])
+	}
+	if got[1].Job != 2 || got[1].Attempts != 1 || !pool.IsPermanent(got[1].Err) {
+		t.Errorf("permanent error must not be retried: %+v", got[1])
+	}
+}
+
+func TestPool_RetryForAndRetryable(t *testing.T) {
+	notFound := errors.New("not found")
+	var mu sync.Mutex
+	calls := map[string]int{}
+	p := pool.New(context.Background(), func(ctx context.Context, job string) error {
+		mu.Lock()
+		calls[job]++
+		mu.Unlock()
+		return notFound
+	}, pool.Config[string]{
+		Workers: 1,
+		Retry:   fastRetry,
+		RetryFor: func(job string) pool.RetryPolicy {
+			if job == "lookup" {
+				r := fastRetry
+				r.Retryable = func(err error) bool { return !errors.Is(err, notFound) }
+				return r
+			}
+			return fastRetry
+		},
+	})
+	_ = p.Submit(context.Background(), "lookup")
+	_ = p.Submit(context.Background(), "sync")
+	p.Stop()
+	p.Wait()
+	if calls["lookup"] != 1 || calls["sync"] != 3 {
+		t.Errorf("want lookup:1 sync:3, have %v", calls)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	r := pool.RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second} {
+		if have := r.Backoff(attempt); have != want {
+			t.Errorf("attempt %d: want %v, have %v", attempt, want, have)
+		}
+	}
+	r.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := r.Backoff(2); d < 100*time.Millisecond || d > 300*time.Millisecond {
+			t.Fatalf("jittered backoff out of range: %v", d)
+		}
+	}
+}
+
+func TestDeadLetterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	sink, err := pool.NewDeadLetterFile[string](path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Put(context.Background(), pool.DeadLetter[string]{Job: "a", Err: errors.New("boom"), Attempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		t.Fatal("no record written")
+	}
+	var rec struct {
+		Job      string `json:"job"`
+		Error    string `json:"error"`
+		Attempts int    `json:"attempts"`
+	}
+	if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Job != "a" || rec.Error != "boom" || rec.Attempts != 3 {
+		t.Errorf("unexpected record %+v", rec)
+	}
+}
+
+// A job failing on its last attempt must be acked on an acking queue even
+// without a DeadLetter: a nack would deliver it again at once, forever.
+func TestPool_FinalFailureAckedWithoutDeadLetter(t *testing.T) {
+	dir := t.TempDir()
+	q := openDiskQueue(t, dir)
+	var mu sync.Mutex
+	calls, reported := 0, 0
+	p := pool.New(context.Background(), func(ctx context.Context, m *diskqueue.Message[int]) error {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return errors.New("boom")
+	}, pool.Config[*diskqueue.Message[int]]{
+		Workers: 1,
+		Queue:   q,
+		Retry:   fastRetry,
+		OnError: func(*diskqueue.Message[int], error) {
+			mu.Lock()
+			reported++
+			mu.Unlock()
+		},
+	})
+	if err := p.Submit(context.Background(), diskqueue.NewMessage(1)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the job to be acked", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return reported == 1 && q.Len() == 0 && q.InFlight() == 0
+	})
+	time.Sleep(20 * time.Millisecond)
+	shutdownWithin(t, p, pool.Drain)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != fastRetry.MaxAttempts || reported != 1 {
+		t.Errorf("want %d attempts and 1 OnError, have %d and %d", fastRetry.MaxAttempts, calls, reported)
+	}
+	if err := q.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	q2 := openDiskQueue(t, dir)
+	defer q2.Shutdown()
+	if n := q2.Len(); n != 0 {
+		t.Errorf("the failed job must not be delivered again, have %d messages", n)
+	}
+}
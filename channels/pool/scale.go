@@ -0,0 +1,118 @@
+package pool
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errIdle = errors.New("pool: idle worker exits")
+
+// Stats is a snapshot of the pool state, useful to tune capacity.
+type Stats struct {
+	// Workers is the current number of workers.
+	Workers int
+	// MinWorkers and MaxWorkers are the autoscaling bounds.
+	MinWorkers int
+	MaxWorkers int
+	// Busy is the number of workers running a job.
+	Busy int
+	// Queued is the number of jobs waiting in the queue.
+	Queued int
+	// AvgJobTime is the moving average of the Handler duration.
+	AvgJobTime time.Duration
+	// CircuitOpen is true while workers pause after repeated panics.
+	CircuitOpen bool
+	// Parked is the number of jobs set aside because their key is at MaxPerKey.
+	Parked int
+}
+
+// Stats returns the current pool state.
+func (p *Pool[T]) Stats() Stats {
+	return Stats{
+		Workers:     p.Workers(),
+		MinWorkers:  p.minWorkers,
+		MaxWorkers:  p.maxWorkers,
+		Busy:        int(atomic.LoadInt64(&p.busy)),
+		Queued:      p.queue.Len(),
+		AvgJobTime:  p.latency.average(),
+		CircuitOpen: p.super.isOpen(),
+		Parked:      p.keys.parkedLen(),
+	}
+}
+
+// maybeGrow adds a worker when jobs are waiting and the pool is below
+// MaxWorkers. Called after every submit and whenever a worker takes a job.
+func (p *Pool[T]) maybeGrow() {
+	if p.maxWorkers == p.minWorkers {
+		return
+	}
+	p.scaleMu.Lock()
+	defer p.scaleMu.Unlock()
+	if p.stopped || p.ctx.Err() != nil {
+		return
+	}
+	size := atomic.LoadInt64(&p.size)
+	if size >= int64(p.maxWorkers) {
+		return
+	}
+	queued := p.queue.Len()
+	if queued == 0 {
+		return
+	}
+	// with no worker left the queued jobs need one whatever the estimate
+	if size > 0 && p.targetWait > 0 {
+		// estimated time the last queued job waits for a worker
+		est := time.Duration(int64(queued) * int64(p.latency.average()) / size)
+		if est <= p.targetWait {
+			return
+		}
+	} else if size > 0 && atomic.LoadInt64(&p.busy) < size {
+		return
+	}
+	p.spawn()
+}
+
+// shrink removes the calling idle worker from the pool if it is above the
+// minimum size. Returns false when the worker must stay.
+func (p *Pool[T]) shrink() bool {
+	p.scaleMu.Lock()
+	defer p.scaleMu.Unlock()
+	if atomic.LoadInt64(&p.size) <= int64(p.minWorkers) {
+		return false
+	}
+	atomic.AddInt64(&p.size, -1)
+	return true
+}
+
+// spawn starts a worker, scaleMu must be held.
+func (p *Pool[T]) spawn() {
+	atomic.AddInt64(&p.size, 1)
+	p.wg.Add(1)
+	go p.worker(&workerState[T]{id: int(atomic.AddInt64(&p.lastID, 1))})
+}
+
+// latencyMeter is an exponentially weighted moving average of job durations.
+type latencyMeter struct {
+	mu  sync.Mutex
+	avg float64
+}
+
+const latencyWeight = 0.2
+
+func (m *latencyMeter) observe(d time.Duration) {
+	m.mu.Lock()
+	if m.avg == 0 {
+		m.avg = float64(d)
+	} else {
+		m.avg += latencyWeight * (float64(d) - m.avg)
+	}
+	m.mu.Unlock()
+}
+
+func (m *latencyMeter) average() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return time.Duration(m.avg)
+}
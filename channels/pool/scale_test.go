@@ -0,0 +1,75 @@
+package pool_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/pool"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPool_AutoscaleGrowsAndShrinks(t *testing.T) {
+	release := make(chan struct{})
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		<-release
+		return nil
+	}, pool.Config[int]{Workers: 1, MaxWorkers: 4, QueueSize: 20, IdleTimeout: 20 * time.Millisecond})
+
+	if s := p.Stats(); s.Workers != 1 || s.MinWorkers != 1 || s.MaxWorkers != 4 {
+		t.Fatalf("unexpected initial stats %+v", s)
+	}
+	for i := 0; i < 10; i++ {
+		if err := p.Submit(context.Background(), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "pool to grow to MaxWorkers", func() bool {
+		s := p.Stats()
+		return s.Workers == 4 && s.Busy == 4
+	})
+	if s := p.Stats(); s.Queued != 6 {
+		t.Errorf("want 6 queued jobs, have %+v", s)
+	}
+
+	close(release)
+	waitFor(t, "idle workers to exit", func() bool {
+		return p.Workers() == 1
+	})
+	p.Stop()
+	p.Wait()
+	if n := p.Workers(); n != 0 {
+		t.Errorf("want 0 workers after Wait, have %d", n)
+	}
+}
+
+func TestPool_AutoscaleTargetWait(t *testing.T) {
+	release := make(chan struct{})
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		<-release
+		return nil
+	}, pool.Config[int]{Workers: 1, MaxWorkers: 4, QueueSize: 20, TargetWait: time.Hour})
+	defer func() {
+		close(release)
+		p.Stop()
+		p.Wait()
+	}()
+
+	for i := 0; i < 10; i++ {
+		_ = p.Submit(context.Background(), i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := p.Workers(); n != 1 {
+		t.Errorf("estimated wait is below TargetWait, want 1 worker, have %d", n)
+	}
+}
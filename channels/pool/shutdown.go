@@ -0,0 +1,114 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/r3code/go-useful-snippets/channels/queue"
+	"github.com/r3code/go-useful-snippets/channels/wait"
+)
+
+// ShutdownMode selects what Shutdown does with queued jobs.
+type ShutdownMode int
+
+const (
+	// Drain stops accepting jobs and lets the workers finish all queued ones.
+	Drain ShutdownMode = iota
+	// Abort stops accepting jobs, the workers finish the job in progress and
+	// exit; queued jobs are returned unprocessed.
+	Abort
+)
+
+// Shutdown stops the pool in the given mode and waits for the workers until
+// ctx is done. When ctx expires first, the pool context is cancelled so
+// running jobs are asked to stop, and ctx.Err() is returned.
+//
+// The returned jobs were not processed: still queued, set aside by the key
+// limiter, dequeued after Abort, or interrupted by the cancellation. On an
+// acking queue they are nacked.
+// Jobs whose Handler is still running when Shutdown returns after the
+// deadline are not included.
+func (p *Pool[T]) Shutdown(ctx context.Context, mode ShutdownMode) ([]T, error) {
+	if mode == Abort {
+		atomic.StoreInt32(&p.aborting, 1)
+		p.keys.abort()
+	}
+	p.closeQ()
+	err := wait.WaitContext(ctx, &p.wg)
+	p.release()
+
+	// the queue is closed, take what it holds now: a job nacked to an acking
+	// queue becomes ready again, so the jobs are settled only after draining
+	var queued []T
+	for n := p.queue.Len(); n > 0; n-- {
+		job, derr := p.queue.Dequeue(context.Background())
+		if errors.Is(derr, queue.ErrClosed) {
+			break
+		}
+		if derr != nil {
+			if p.onError != nil {
+				var zero T
+				p.onError(zero, fmt.Errorf("pool: dequeue: %w", derr))
+			}
+			continue
+		}
+		queued = append(queued, job)
+	}
+	for _, job := range queued {
+		p.leave(context.Background(), unstarted(job), true)
+	}
+	for _, job := range p.keys.drain() {
+		p.leave(context.Background(), unstarted(job), true)
+	}
+	p.nackLeft()
+
+	p.leftMu.Lock()
+	defer p.leftMu.Unlock()
+	left := p.leftovers
+	p.leftovers = nil
+	return left, err
+}
+
+// leave records an unprocessed job and reports it to Hooks.Dropped, nack
+// tells whether it still has to be nacked on an acking queue. The nack is
+// deferred to nackLeft: a nacked job is ready again and would be dequeued
+// and left once more.
+func (p *Pool[T]) leave(ctx context.Context, ev Event[T], nack bool) {
+	job := ev.Job
+	_, acking := p.queue.(queue.Acker[T])
+	p.leftMu.Lock()
+	p.leftovers = append(p.leftovers, job)
+	if acking && nack {
+		p.unsettled = append(p.unsettled, job)
+	}
+	p.leftMu.Unlock()
+	if ev.Err = p.ctx.Err(); ev.Err == nil {
+		ev.Err = queue.ErrClosed // aborted
+	}
+	p.hooks.Dropped(ctx, ev)
+}
+
+// nackLeft nacks the jobs left since the last call, once the workers no
+// longer take jobs from the queue.
+func (p *Pool[T]) nackLeft() {
+	p.leftMu.Lock()
+	jobs := p.unsettled
+	p.unsettled = nil
+	p.leftMu.Unlock()
+	acker, ok := p.queue.(queue.Acker[T])
+	if !ok {
+		return
+	}
+	for _, job := range jobs {
+		if err := acker.Nack(job); err != nil && p.onError != nil {
+			p.onError(job, fmt.Errorf("pool: nack unprocessed job: %w", err))
+		}
+	}
+}
+
+// unstarted is the event of a job dropped before a worker started it.
+func unstarted[T any](job T) Event[T] {
+	return Event[T]{Job: job, Wait: -1}
+}
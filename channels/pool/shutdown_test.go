@@ -0,0 +1,212 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/diskqueue"
+	"github.com/r3code/go-useful-snippets/channels/pool"
+)
+
+func TestPool_ShutdownDrain(t *testing.T) {
+	var processed int64
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		time.Sleep(time.Millisecond)
+		atomic.AddInt64(&processed, 1)
+		return nil
+	}, pool.Config[int]{Workers: 2, QueueSize: 10})
+	for i := 0; i < 10; i++ {
+		_ = p.Submit(context.Background(), i)
+	}
+	left, err := p.Shutdown(context.Background(), pool.Drain)
+	if err != nil || len(left) != 0 {
+		t.Errorf("want nil and no leftovers, have %v %v", err, left)
+	}
+	if processed != 10 {
+		t.Errorf("drain must process all queued jobs, processed %d", processed)
+	}
+}
+
+func TestPool_ShutdownAbort(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var processed int64
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		if n == 0 {
+			close(started)
+			<-release
+		}
+		atomic.AddInt64(&processed, 1)
+		return nil
+	}, pool.Config[int]{Workers: 1, QueueSize: 10})
+	for i := 0; i < 6; i++ {
+		_ = p.Submit(context.Background(), i)
+	}
+	<-started
+
+	type result struct {
+		left []int
+		err  error
+	}
+	done := make(chan result)
+	go func() {
+		left, err := p.Shutdown(context.Background(), pool.Abort)
+		done <- result{left, err}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	res := <-done
+
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if processed != 1 {
+		t.Errorf("abort must finish only the job in progress, processed %d", processed)
+	}
+	sort.Ints(res.left)
+	if len(res.left) != 5 || res.left[0] != 1 || res.left[4] != 5 {
+		t.Errorf("want leftovers [1..5], have %v", res.left)
+	}
+	if err := p.TrySubmit(7); err == nil {
+		t.Error("pool must reject jobs after Shutdown")
+	}
+}
+
+func TestPool_ShutdownDeadline(t *testing.T) {
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, pool.Config[int]{Workers: 1, QueueSize: 10})
+	for i := 0; i < 4; i++ {
+		_ = p.Submit(context.Background(), i)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	left, err := p.Shutdown(ctx, pool.Drain)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want DeadlineExceeded, have %v", err)
+	}
+	queued := 0
+	for _, n := range left {
+		if n > 0 {
+			queued++
+		}
+	}
+	if queued != 3 {
+		t.Errorf("want the 3 queued jobs returned, have %v", left)
+	}
+	p.Wait()
+}
+
+func openDiskQueue(t *testing.T, dir string) *diskqueue.Queue[int] {
+	t.Helper()
+	q, err := diskqueue.Open(diskqueue.Config[int]{Dir: dir, Capacity: 100, Sync: diskqueue.SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// shutdownWithin runs Shutdown and fails the test if it hangs.
+func shutdownWithin(t *testing.T, p *pool.Pool[*diskqueue.Message[int]], mode pool.ShutdownMode) []*diskqueue.Message[int] {
+	t.Helper()
+	type result struct {
+		left []*diskqueue.Message[int]
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		left, err := p.Shutdown(context.Background(), mode)
+		done <- result{left, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		return res.left
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown hangs")
+		return nil
+	}
+}
+
+// Abort on an acking queue must not loop on its own nacks: the leftovers are
+// returned once and stay on disk for the next Open.
+func TestPool_ShutdownAbortDiskQueue(t *testing.T) {
+	dir := t.TempDir()
+	q := openDiskQueue(t, dir)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p := pool.New(context.Background(), func(ctx context.Context, m *diskqueue.Message[int]) error {
+		if m.Value == 0 {
+			close(started)
+			<-release
+		}
+		return nil
+	}, pool.Config[*diskqueue.Message[int]]{Workers: 1, Queue: q})
+	for i := 0; i < 6; i++ {
+		if err := p.Submit(context.Background(), diskqueue.NewMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-started
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	left := shutdownWithin(t, p, pool.Abort)
+
+	seen := map[uint64]bool{}
+	for _, m := range left {
+		if seen[m.ID] {
+			t.Errorf("message %d returned twice", m.ID)
+		}
+		seen[m.ID] = true
+	}
+	if err := q.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	q2 := openDiskQueue(t, dir)
+	defer q2.Shutdown()
+	if len(left) != 5 {
+		t.Errorf("want 5 leftovers, have %d", len(left))
+	}
+	if n := q2.Len(); n != 5 {
+		t.Errorf("want the 5 leftovers kept on disk, have %d", n)
+	}
+}
+
+func TestPool_ShutdownDrainDiskQueue(t *testing.T) {
+	dir := t.TempDir()
+	q := openDiskQueue(t, dir)
+	var processed int64
+	p := pool.New(context.Background(), func(ctx context.Context, m *diskqueue.Message[int]) error {
+		time.Sleep(time.Millisecond)
+		atomic.AddInt64(&processed, 1)
+		return nil
+	}, pool.Config[*diskqueue.Message[int]]{Workers: 2, Queue: q})
+	for i := 0; i < 10; i++ {
+		if err := p.Submit(context.Background(), diskqueue.NewMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if left := shutdownWithin(t, p, pool.Drain); len(left) != 0 {
+		t.Errorf("want no leftovers, have %d", len(left))
+	}
+	if processed != 10 {
+		t.Errorf("drain must process all queued jobs, processed %d", processed)
+	}
+	if err := q.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	q2 := openDiskQueue(t, dir)
+	defer q2.Shutdown()
+	if n := q2.Len(); n != 0 {
+		t.Errorf("all messages were acked, want empty queue, have %d", n)
+	}
+}
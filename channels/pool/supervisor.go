@@ -0,0 +1,210 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by Submit while the panic circuit is open.
+var ErrCircuitOpen = errors.New("pool: circuit open after repeated panics")
+
+// PanicError is the error a job fails with when its Handler panicked.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("pool: handler panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Supervision configures panic handling. A panic never crashes the process:
+// it is recovered and the job fails with a *PanicError (not retried unless
+// RetryPolicy.Retryable says so). The worker then restarts after a backoff.
+type Supervision struct {
+	// RestartBackoff computes the pause of a worker after its n-th panic in a
+	// row, 100ms doubling up to 30s by default. Only the backoff fields are used.
+	RestartBackoff RetryPolicy
+	// MaxPanics is the number of panics within Window that opens the circuit:
+	// workers pause and Submit returns ErrCircuitOpen for CoolDown.
+	// Zero disables the circuit.
+	MaxPanics int
+	// Window is the period MaxPanics are counted in, one minute by default.
+	Window time.Duration
+	// CoolDown is how long the circuit stays open, 30 seconds by default.
+	CoolDown time.Duration
+}
+
+// PanicEvent describes a recovered panic, passed to Config.OnPanic.
+type PanicEvent[T any] struct {
+	WorkerID int
+	Job      T
+	Err      *PanicError
+	// Consecutive is the number of panics in a row of this worker.
+	Consecutive int
+	// Restart is the pause before the worker takes the next job.
+	Restart time.Duration
+	// CircuitOpen is true when this panic opened the circuit.
+	CircuitOpen bool
+}
+
+// Logger is the logging interface of the log package in this repository
+// (go-kit style), declared here to keep the pool free of dependencies.
+type Logger interface {
+	Log(keyvals ...interface{}) error
+}
+
+// LogPanics returns an OnPanic hook writing the events to logger:
+//
+//	pool.Config[Job]{OnPanic: pool.LogPanics[Job](log.MustCreateComponentLog(logger, "jobs"))}
+func LogPanics[T any](logger Logger) func(ev PanicEvent[T]) {
+	return func(ev PanicEvent[T]) {
+		_ = logger.Log(
+			"level", "error",
+			"msg", "worker panic recovered",
+			"worker", ev.WorkerID,
+			"panic", fmt.Sprint(ev.Err.Value),
+			"consecutive", ev.Consecutive,
+			"restart_in", ev.Restart,
+			"circuit_open", ev.CircuitOpen,
+			"stack", string(ev.Err.Stack),
+		)
+	}
+}
+
+type workerState[T any] struct {
+	id       int
+	panicked bool // the last job panicked
+	panics   int  // panics in a row
+	// handoff is a job set aside by the key limiter, run next
+	handoff    T
+	handoffAt  time.Time // enqueue time of handoff
+	hasHandoff bool
+}
+
+// call runs the Handler converting a panic to *PanicError.
+func (p *Pool[T]) call(ctx context.Context, w *workerState[T], job T) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			perr := &PanicError{Value: r, Stack: debug.Stack()}
+			w.panicked = true
+			w.panics++
+			opened := p.super.panicked(time.Now())
+			if p.onPanic != nil {
+				p.onPanic(PanicEvent[T]{
+					WorkerID:    w.id,
+					Job:         job,
+					Err:         perr,
+					Consecutive: w.panics,
+					Restart:     p.super.backoff(w.panics),
+					CircuitOpen: opened,
+				})
+			}
+			err = perr
+		}
+	}()
+	err = p.handler(ctx, job)
+	w.panics = 0
+	return err
+}
+
+// restart pauses a worker after a panic. Returns false when the pool is
+// cancelled meanwhile.
+func (p *Pool[T]) restart(w *workerState[T]) bool {
+	timer := time.NewTimer(p.super.backoff(w.panics))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// supervisor counts panics and keeps the circuit state.
+type supervisor struct {
+	cfg Supervision
+
+	mu        sync.Mutex
+	panics    []time.Time
+	openUntil time.Time
+}
+
+func newSupervisor(cfg Supervision) *supervisor {
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+	if cfg.CoolDown <= 0 {
+		cfg.CoolDown = 30 * time.Second
+	}
+	return &supervisor{cfg: cfg}
+}
+
+func (s *supervisor) backoff(panics int) time.Duration {
+	return s.cfg.RestartBackoff.Backoff(panics)
+}
+
+// panicked records a panic and reports whether it opened the circuit.
+func (s *supervisor) panicked(now time.Time) bool {
+	if s.cfg.MaxPanics <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recent := s.panics[:0]
+	for _, t := range s.panics {
+		if now.Sub(t) < s.cfg.Window {
+			recent = append(recent, t)
+		}
+	}
+	s.panics = append(recent, now)
+	if len(s.panics) < s.cfg.MaxPanics || now.Before(s.openUntil) {
+		return false
+	}
+	s.openUntil = now.Add(s.cfg.CoolDown)
+	s.panics = s.panics[:0]
+	return true
+}
+
+func (s *supervisor) isOpen() bool {
+	if s.cfg.MaxPanics <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().Before(s.openUntil)
+}
+
+// waitClosed blocks while the circuit is open. Returns false if ctx is done.
+func (s *supervisor) waitClosed(ctx context.Context) bool {
+	for {
+		if s.cfg.MaxPanics <= 0 {
+			return true
+		}
+		s.mu.Lock()
+		wait := time.Until(s.openUntil)
+		s.mu.Unlock()
+		if wait <= 0 {
+			return true
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/pool"
+)
+
+// memLogger collects keyvals like log.Logger does
+type memLogger struct {
+	mu      sync.Mutex
+	records [][]interface{}
+}
+
+func (l *memLogger) Log(keyvals ...interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, keyvals)
+	return nil
+}
+
+func TestPool_PanicRecovered(t *testing.T) {
+	logger := &memLogger{}
+	var mu sync.Mutex
+	var errs []error
+	var done []int
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		if n == 1 {
+			panic("bad job")
+		}
+		mu.Lock()
+		done = append(done, n)
+		mu.Unlock()
+		return nil
+	}, pool.Config[int]{
+		Workers:     1,
+		Retry:       fastRetry,
+		Supervision: pool.Supervision{RestartBackoff: pool.RetryPolicy{InitialBackoff: time.Millisecond}},
+		OnPanic:     pool.LogPanics[int](logger),
+		OnError: func(n int, err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		},
+	})
+	_ = p.Submit(context.Background(), 1)
+	_ = p.Submit(context.Background(), 2)
+	p.Stop()
+	p.Wait()
+
+	if len(done) != 1 || done[0] != 2 {
+		t.Errorf("worker must survive the panic and process job 2, done %v", done)
+	}
+	var perr *pool.PanicError
+	if len(errs) != 1 || !errors.As(errs[0], &perr) {
+		t.Fatalf("want one PanicError, have %v", errs)
+	}
+	if perr.Value != "bad job" || !strings.Contains(string(perr.Stack), "supervisor_test.go") {
+		t.Errorf("panic value or stack missing: %v\n%s", perr.Value, perr.Stack)
+	}
+	if len(logger.records) != 1 {
+		t.Fatalf("want the panic logged once (panics are not retried), have %d", len(logger.records))
+	}
+	rec := logger.records[0]
+	if rec[0] != "level" || rec[1] != "error" || rec[6] != "panic" || rec[7] != "bad job" {
+		t.Errorf("unexpected log record %v", rec[:8])
+	}
+}
+
+func TestPool_CircuitOpensOnRepeatedPanics(t *testing.T) {
+	var mu sync.Mutex
+	var events []pool.PanicEvent[int]
+	p := pool.New(context.Background(), func(ctx context.Context, n int) error {
+		panic(n)
+	}, pool.Config[int]{
+		Workers:   1,
+		QueueSize: 10,
+		Supervision: pool.Supervision{
+			RestartBackoff: pool.RetryPolicy{InitialBackoff: time.Millisecond},
+			MaxPanics:      3,
+			CoolDown:       50 * time.Millisecond,
+		},
+		OnPanic: func(ev pool.PanicEvent[int]) {
+			mu.Lock()
+			events = append(events, ev)
+			mu.Unlock()
+		},
+	})
+	defer func() {
+		p.Stop()
+		p.Wait()
+	}()
+	for i := 0; i < 5; i++ {
+		_ = p.Submit(context.Background(), i)
+	}
+	waitFor(t, "circuit to open", func() bool { return p.Stats().CircuitOpen })
+	if err := p.TrySubmit(9); !errors.Is(err, pool.ErrCircuitOpen) {
+		t.Errorf("want ErrCircuitOpen, have %v", err)
+	}
+	mu.Lock()
+	n := len(events)
+	last := events[n-1]
+	mu.Unlock()
+	if n != 3 || !last.CircuitOpen || last.Consecutive != 3 || last.WorkerID != 1 {
+		t.Errorf("unexpected events: %d, last %+v", n, last)
+	}
+	// jobs wait in the queue while the circuit is open
+	if q := p.Len(); q != 2 {
+		t.Errorf("want 2 queued jobs while open, have %d", q)
+	}
+	waitFor(t, "circuit to close", func() bool { return !p.Stats().CircuitOpen })
+	if err := p.TrySubmit(9); err != nil {
+		t.Errorf("want the pool to accept jobs after CoolDown, have %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultMaxRetryAfter caps the Retry-After header value.
+const DefaultMaxRetryAfter = time.Minute
+
+// StatsProvider is implemented by queues that report their depth, e.g. Bounded.
+type StatsProvider interface {
+	Stats() Stats
+}
+
+// RejectWhenFull is a middleware answering 503 Service Unavailable with a
+// Retry-After header while q is full, and passing requests to next otherwise.
+//
+// It only looks at the queue depth; a request passed to next may still find
+// the queue full, so next should use TryEnqueue and WriteRetryAfter as well.
+//
+//	http.Handle("/jobs", queue.RejectWhenFull(q, jobsHandler))
+func RejectWhenFull(q StatsProvider, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s := q.Stats(); s.Full() {
+			WriteRetryAfter(w, s)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// EnqueueHandler decodes a job from the request and puts it into q without
+// blocking. Responds 202 Accepted on success, 503 with Retry-After when q is
+// full, 400 when decode fails and 503 without Retry-After when q is closed.
+func EnqueueHandler[T any](q *Bounded[T], decode func(r *http.Request) (T, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		job, err := decode(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch err := q.TryEnqueue(job); {
+		case err == nil:
+			w.WriteHeader(http.StatusAccepted)
+		case errors.Is(err, ErrFull):
+			WriteRetryAfter(w, q.Stats())
+		default:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// WriteRetryAfter writes a 503 response with Retry-After computed by
+// Stats.RetryAfter capped with DefaultMaxRetryAfter.
+func WriteRetryAfter(w http.ResponseWriter, s Stats) {
+	secs := int(s.RetryAfter(DefaultMaxRetryAfter) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, "max capacity reached", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,303 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrUnknownLane is returned when LaneOf maps a job to a lane that is not configured.
+var ErrUnknownLane = errors.New("queue: unknown lane")
+
+// Lane describes one priority lane of a Priority queue.
+type Lane struct {
+	// Name identifies the lane, e.g. "high".
+	Name string
+	// Weight is the share of dequeues the lane gets while all lanes are busy,
+	// e.g. weights 8, 3, 1 serve 8 high, 3 medium and 1 low job out of 12.
+	Weight int
+	// Capacity is the maximum number of queued jobs in the lane.
+	Capacity int
+}
+
+// PriorityConfig configures a Priority queue.
+type PriorityConfig[T any] struct {
+	// Lanes lists the lanes, at least one is required.
+	Lanes []Lane
+	// LaneOf returns the lane name for a job.
+	LaneOf func(job T) string
+	// MaxWait is the starvation protection: a job waiting longer than MaxWait
+	// is dequeued next regardless of the weights. Zero disables it.
+	MaxWait time.Duration
+}
+
+// LaneStats is a snapshot of a single lane.
+type LaneStats struct {
+	Name     string
+	Len      int
+	Cap      int
+	Enqueued uint64
+	Dequeued uint64
+	Rejected uint64
+	// Promoted counts jobs dequeued out of turn by the starvation protection.
+	Promoted uint64
+	// AvgWait is the moving average of the time jobs spent in the lane.
+	AvgWait time.Duration
+}
+
+// Priority is a Queue with several lanes served by smooth weighted
+// round-robin, so bulk work cannot starve interactive jobs and vice versa.
+type Priority[T any] struct {
+	laneOf  func(job T) string
+	maxWait time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	lanes   []*lane[T]
+	byName  map[string]*lane[T]
+	changed chan struct{}
+	closed  bool
+	drain   drainMeter
+}
+
+type lane[T any] struct {
+	Lane
+	items   []item[T]
+	current int // smooth weighted round-robin state
+	stats   LaneStats
+}
+
+type item[T any] struct {
+	job T
+	at  time.Time
+}
+
+// NewPriority creates a priority queue with the given lanes.
+func NewPriority[T any](cfg PriorityConfig[T]) (*Priority[T], error) {
+	if len(cfg.Lanes) == 0 {
+		return nil, errors.New("queue: at least one lane is required")
+	}
+	if cfg.LaneOf == nil {
+		return nil, errors.New("queue: LaneOf is required")
+	}
+	q := &Priority[T]{
+		laneOf:  cfg.LaneOf,
+		maxWait: cfg.MaxWait,
+		now:     time.Now,
+		byName:  make(map[string]*lane[T], len(cfg.Lanes)),
+		changed: make(chan struct{}),
+	}
+	for _, l := range cfg.Lanes {
+		if l.Weight <= 0 || l.Capacity <= 0 {
+			return nil, fmt.Errorf("queue: lane %q must have positive weight and capacity", l.Name)
+		}
+		if _, dup := q.byName[l.Name]; dup {
+			return nil, fmt.Errorf("queue: duplicate lane %q", l.Name)
+		}
+		ln := &lane[T]{Lane: l, stats: LaneStats{Name: l.Name, Cap: l.Capacity}}
+		q.lanes = append(q.lanes, ln)
+		q.byName[l.Name] = ln
+	}
+	return q, nil
+}
+
+// Enqueue implements Queue, blocking while the job's lane is full.
+func (q *Priority[T]) Enqueue(ctx context.Context, job T) error {
+	name := q.laneOf(job)
+	for {
+		q.mu.Lock()
+		ok, err := q.put(name, job)
+		changed := q.changed
+		q.mu.Unlock()
+		if ok || err != nil {
+			return err
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			q.mu.Lock()
+			q.byName[name].stats.Rejected++
+			q.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// TryEnqueue implements Queue, returns ErrFull when the job's lane is full.
+func (q *Priority[T]) TryEnqueue(job T) error {
+	name := q.laneOf(job)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ok, err := q.put(name, job)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		q.byName[name].stats.Rejected++
+		return ErrFull
+	}
+	return nil
+}
+
+// Dequeue implements Queue.
+func (q *Priority[T]) Dequeue(ctx context.Context) (T, error) {
+	job, _, err := q.DequeueTimed(ctx)
+	return job, err
+}
+
+// DequeueTimed implements Timed.
+func (q *Priority[T]) DequeueTimed(ctx context.Context) (T, time.Time, error) {
+	for {
+		q.mu.Lock()
+		if it, ok := q.take(); ok {
+			q.mu.Unlock()
+			q.drain.tick()
+			return it.job, it.at, nil
+		}
+		closed := q.closed
+		changed := q.changed
+		q.mu.Unlock()
+		var zero T
+		if closed {
+			return zero, time.Time{}, ErrClosed
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return zero, time.Time{}, ctx.Err()
+		}
+	}
+}
+
+// Len implements Queue.
+func (q *Priority[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, l := range q.lanes {
+		n += len(l.items)
+	}
+	return n
+}
+
+// Cap implements Queue, it is the sum of the lane capacities.
+func (q *Priority[T]) Cap() int {
+	n := 0
+	for _, l := range q.lanes {
+		n += l.Capacity
+	}
+	return n
+}
+
+// Close implements Queue.
+func (q *Priority[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.notify()
+	}
+}
+
+// Stats returns the totals over all lanes, see StatsProvider.
+func (q *Priority[T]) Stats() Stats {
+	s := Stats{Cap: q.Cap(), DequeueEvery: q.drain.interval()}
+	for _, l := range q.LaneStats() {
+		s.Len += l.Len
+		s.Enqueued += l.Enqueued
+		s.Dequeued += l.Dequeued
+		s.Rejected += l.Rejected
+	}
+	return s
+}
+
+// LaneStats returns per-lane metrics in the configured lane order.
+func (q *Priority[T]) LaneStats() []LaneStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]LaneStats, len(q.lanes))
+	for i, l := range q.lanes {
+		out[i] = l.stats
+		out[i].Len = len(l.items)
+	}
+	return out
+}
+
+// put adds the job to its lane, ok is false when the lane is full. mu must be held.
+func (q *Priority[T]) put(name string, job T) (ok bool, err error) {
+	if q.closed {
+		return false, ErrClosed
+	}
+	l, found := q.byName[name]
+	if !found {
+		return false, fmt.Errorf("%w: %q", ErrUnknownLane, name)
+	}
+	if len(l.items) >= l.Capacity {
+		return false, nil
+	}
+	l.items = append(l.items, item[T]{job: job, at: q.now()})
+	l.stats.Enqueued++
+	q.notify()
+	return true, nil
+}
+
+// take removes the next job according to the weights and the starvation
+// protection. mu must be held.
+func (q *Priority[T]) take() (it item[T], ok bool) {
+	now := q.now()
+	var next *lane[T]
+	promoted := false
+	if q.maxWait > 0 {
+		// the most overdue head of line wins
+		var oldest time.Time
+		for _, l := range q.lanes {
+			if len(l.items) > 0 && now.Sub(l.items[0].at) > q.maxWait &&
+				(next == nil || l.items[0].at.Before(oldest)) {
+				next, oldest = l, l.items[0].at
+			}
+		}
+		promoted = next != nil
+	}
+	if next == nil {
+		total := 0
+		for _, l := range q.lanes {
+			if len(l.items) == 0 {
+				continue
+			}
+			l.current += l.Weight
+			total += l.Weight
+			if next == nil || l.current > next.current {
+				next = l
+			}
+		}
+		if next == nil {
+			return it, false
+		}
+		next.current -= total
+	}
+
+	it = next.items[0]
+	var zero item[T]
+	next.items[0] = zero
+	next.items = next.items[1:]
+	next.stats.Dequeued++
+	if promoted {
+		next.stats.Promoted++
+	}
+	waited := float64(now.Sub(it.at))
+	if next.stats.AvgWait == 0 {
+		next.stats.AvgWait = time.Duration(waited)
+	} else {
+		next.stats.AvgWait += time.Duration(ewmaWeight * (waited - float64(next.stats.AvgWait)))
+	}
+	q.notify()
+	return it, true
+}
+
+// notify wakes up everybody waiting for a change. mu must be held.
+func (q *Priority[T]) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
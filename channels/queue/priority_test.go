@@ -0,0 +1,127 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/queue"
+)
+
+type task struct {
+	lane string
+	id   int
+}
+
+func newPriority(t *testing.T, maxWait time.Duration) *queue.Priority[task] {
+	t.Helper()
+	q, err := queue.NewPriority(queue.PriorityConfig[task]{
+		Lanes: []queue.Lane{
+			{Name: "high", Weight: 8, Capacity: 100},
+			{Name: "medium", Weight: 3, Capacity: 100},
+			{Name: "low", Weight: 1, Capacity: 100},
+		},
+		LaneOf:  func(j task) string { return j.lane },
+		MaxWait: maxWait,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestPriority_WeightedFair(t *testing.T) {
+	q := newPriority(t, 0)
+	for i := 0; i < 24; i++ {
+		for _, l := range []string{"low", "medium", "high"} {
+			if err := q.TryEnqueue(task{l, i}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	served := map[string]int{}
+	for i := 0; i < 24; i++ {
+		j, err := q.Dequeue(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		served[j.lane]++
+	}
+	if served["high"] != 16 || served["medium"] != 6 || served["low"] != 2 {
+		t.Errorf("want 16:6:2, have %v", served)
+	}
+	// FIFO inside a lane
+	j, _ := q.Dequeue(context.Background())
+	if j.lane != "high" || j.id != 16 {
+		t.Errorf("want high/16, have %+v", j)
+	}
+}
+
+func TestPriority_StarvationProtection(t *testing.T) {
+	q := newPriority(t, time.Millisecond)
+	_ = q.TryEnqueue(task{"low", 1})
+	time.Sleep(5 * time.Millisecond)
+	_ = q.TryEnqueue(task{"high", 1})
+	j, _ := q.Dequeue(context.Background())
+	if j.lane != "low" {
+		t.Errorf("overdue low job must be served first, have %+v", j)
+	}
+	if s := q.LaneStats()[2]; s.Promoted != 1 || s.Dequeued != 1 || s.AvgWait < 5*time.Millisecond {
+		t.Errorf("unexpected low lane stats %+v", s)
+	}
+}
+
+func TestPriority_LaneCapacityAndErrors(t *testing.T) {
+	q, err := queue.NewPriority(queue.PriorityConfig[task]{
+		Lanes:  []queue.Lane{{Name: "only", Weight: 1, Capacity: 1}},
+		LaneOf: func(j task) string { return j.lane },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.TryEnqueue(task{"only", 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.TryEnqueue(task{"only", 2}); !errors.Is(err, queue.ErrFull) {
+		t.Errorf("want ErrFull, have %v", err)
+	}
+	if err := q.TryEnqueue(task{"nope", 1}); !errors.Is(err, queue.ErrUnknownLane) {
+		t.Errorf("want ErrUnknownLane, have %v", err)
+	}
+}
+
+func TestPriority_BlockingEnqueueAndClose(t *testing.T) {
+	q := newPriority(t, 0)
+	for i := 0; i < 100; i++ {
+		_ = q.TryEnqueue(task{"low", i})
+	}
+	errc := make(chan error)
+	go func() { errc <- q.Enqueue(context.Background(), task{"low", 100}) }()
+	if _, err := q.Dequeue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("blocked Enqueue must succeed after a dequeue, have %v", err)
+	}
+	q.Close()
+	if err := q.TryEnqueue(task{"high", 1}); !errors.Is(err, queue.ErrClosed) {
+		t.Errorf("want ErrClosed, have %v", err)
+	}
+	n := 0
+	for {
+		if _, err := q.Dequeue(context.Background()); err != nil {
+			if !errors.Is(err, queue.ErrClosed) {
+				t.Fatal(err)
+			}
+			break
+		}
+		n++
+	}
+	if n != 100 {
+		t.Errorf("want 100 drained jobs, have %d", n)
+	}
+	if s := q.Stats(); s.Enqueued != 101 || s.Dequeued != 101 || s.Cap != 300 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
@@ -0,0 +1,193 @@
+// Package queue defines the job queue used between producers and the worker
+// pool (see channels/pool) together with its in-memory implementation.
+//
+// It replaces the `make(chan Job, 100)` + `TryEnqueue` snippets: sending on a
+// closed channel no longer panics and a full queue is reported with an error.
+// Bounded is a plain FIFO, Priority serves several weighted lanes.
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrFull is returned by TryEnqueue when the queue has no free slot.
+	ErrFull = errors.New("queue: full")
+	// ErrClosed is returned by Enqueue after Close, and by Dequeue once a
+	// closed queue has been drained.
+	ErrClosed = errors.New("queue: closed")
+)
+
+// Queue is a FIFO of jobs shared by producers and workers.
+// All methods are safe for concurrent use.
+type Queue[T any] interface {
+	// Enqueue blocks until the job is accepted, ctx is done or the queue is closed.
+	Enqueue(ctx context.Context, job T) error
+	// TryEnqueue adds the job without blocking, returns ErrFull when there is no room.
+	TryEnqueue(job T) error
+	// Dequeue blocks until a job is available, ctx is done or the queue is
+	// closed and drained.
+	Dequeue(ctx context.Context) (T, error)
+	// Len returns the number of queued jobs.
+	Len() int
+	// Cap returns the queue capacity.
+	Cap() int
+	// Close stops accepting new jobs. Jobs already queued can still be dequeued.
+	Close()
+}
+
+// Acker is implemented by queues with at-least-once delivery, e.g. the disk
+// backed one in channels/diskqueue. A consumer acknowledges every dequeued
+// job: Ack when it was processed, Nack to put it back for another attempt.
+// Jobs neither acked nor nacked are delivered again after a restart.
+type Acker[T any] interface {
+	Ack(job T) error
+	Nack(job T) error
+}
+
+// Timed is implemented by queues that record when each job was enqueued.
+// The pool uses it to report how long jobs wait in the queue.
+type Timed[T any] interface {
+	// DequeueTimed is Dequeue also returning the enqueue time of the job.
+	DequeueTimed(ctx context.Context) (job T, enqueued time.Time, err error)
+}
+
+// Bounded is a channel backed Queue with a fixed capacity.
+type Bounded[T any] struct {
+	ch      chan stamped[T]
+	closing chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+	once   sync.Once
+
+	enqueued uint64
+	rejected uint64
+	drain    drainMeter
+}
+
+// NewBounded creates a queue holding up to capacity jobs.
+// With capacity 0 every Enqueue waits for a worker to take the job.
+func NewBounded[T any](capacity int) *Bounded[T] {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &Bounded[T]{
+		ch:      make(chan stamped[T], capacity),
+		closing: make(chan struct{}),
+	}
+}
+
+// Enqueue implements Queue.
+func (q *Bounded[T]) Enqueue(ctx context.Context, job T) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrClosed
+	}
+	select {
+	case q.ch <- stamped[T]{job: job, at: time.Now()}:
+		atomic.AddUint64(&q.enqueued, 1)
+		return nil
+	case <-q.closing:
+		return ErrClosed
+	case <-ctx.Done():
+		atomic.AddUint64(&q.rejected, 1)
+		return ctx.Err()
+	}
+}
+
+// EnqueueTimeout is Enqueue waiting at most d for a free slot.
+// Returns ErrFull when the queue stayed full for d.
+func (q *Bounded[T]) EnqueueTimeout(ctx context.Context, job T, d time.Duration) error {
+	tctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	err := q.Enqueue(tctx, job)
+	if err != nil && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		return ErrFull
+	}
+	return err
+}
+
+// TryEnqueue implements Queue.
+func (q *Bounded[T]) TryEnqueue(job T) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrClosed
+	}
+	select {
+	case q.ch <- stamped[T]{job: job, at: time.Now()}:
+		atomic.AddUint64(&q.enqueued, 1)
+		return nil
+	default:
+		atomic.AddUint64(&q.rejected, 1)
+		return ErrFull
+	}
+}
+
+// Dequeue implements Queue.
+func (q *Bounded[T]) Dequeue(ctx context.Context) (T, error) {
+	job, _, err := q.DequeueTimed(ctx)
+	return job, err
+}
+
+// DequeueTimed implements Timed.
+func (q *Bounded[T]) DequeueTimed(ctx context.Context) (T, time.Time, error) {
+	select {
+	case s, ok := <-q.ch:
+		if !ok {
+			var zero T
+			return zero, time.Time{}, ErrClosed
+		}
+		q.drain.tick()
+		return s.job, s.at, nil
+	case <-ctx.Done():
+		var zero T
+		return zero, time.Time{}, ctx.Err()
+	}
+}
+
+// Len implements Queue.
+func (q *Bounded[T]) Len() int {
+	return len(q.ch)
+}
+
+// Cap implements Queue.
+func (q *Bounded[T]) Cap() int {
+	return cap(q.ch)
+}
+
+// Stats returns a snapshot of the queue counters.
+func (q *Bounded[T]) Stats() Stats {
+	return Stats{
+		Len:          len(q.ch),
+		Cap:          cap(q.ch),
+		Enqueued:     atomic.LoadUint64(&q.enqueued),
+		Dequeued:     q.drain.count(),
+		Rejected:     atomic.LoadUint64(&q.rejected),
+		DequeueEvery: q.drain.interval(),
+	}
+}
+
+// Close implements Queue.
+func (q *Bounded[T]) Close() {
+	q.once.Do(func() {
+		// wake up blocked producers first, they hold the read lock
+		close(q.closing)
+		q.mu.Lock()
+		q.closed = true
+		close(q.ch)
+		q.mu.Unlock()
+	})
+}
+
+// stamped is a queued job with its enqueue time.
+type stamped[T any] struct {
+	job T
+	at  time.Time
+}
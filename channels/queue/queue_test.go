@@ -0,0 +1,136 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/queue"
+)
+
+func TestBounded_TryEnqueueAndStats(t *testing.T) {
+	q := queue.NewBounded[int](2)
+	if err := q.TryEnqueue(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.TryEnqueue(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.TryEnqueue(3); !errors.Is(err, queue.ErrFull) {
+		t.Errorf("want ErrFull, have %v", err)
+	}
+	if _, err := q.Dequeue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s := q.Stats()
+	if s.Len != 1 || s.Cap != 2 || s.Enqueued != 2 || s.Dequeued != 1 || s.Rejected != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestBounded_EnqueueTimeout(t *testing.T) {
+	q := queue.NewBounded[int](1)
+	_ = q.TryEnqueue(1)
+	if err := q.EnqueueTimeout(context.Background(), 2, 10*time.Millisecond); !errors.Is(err, queue.ErrFull) {
+		t.Errorf("want ErrFull, have %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := q.EnqueueTimeout(ctx, 2, time.Second); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, have %v", err)
+	}
+}
+
+func TestBounded_CloseUnblocksProducersAndDrains(t *testing.T) {
+	q := queue.NewBounded[int](1)
+	_ = q.TryEnqueue(1)
+	errc := make(chan error)
+	go func() { errc <- q.Enqueue(context.Background(), 2) }()
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+	if err := <-errc; !errors.Is(err, queue.ErrClosed) {
+		t.Errorf("blocked Enqueue: want ErrClosed, have %v", err)
+	}
+	if v, err := q.Dequeue(context.Background()); v != 1 || err != nil {
+		t.Errorf("queued job must survive Close, have %v %v", v, err)
+	}
+	if _, err := q.Dequeue(context.Background()); !errors.Is(err, queue.ErrClosed) {
+		t.Errorf("drained queue: want ErrClosed, have %v", err)
+	}
+}
+
+func TestStats_RetryAfter(t *testing.T) {
+	s := queue.Stats{Len: 10, Cap: 10, DequeueEvery: 250 * time.Millisecond}
+	if d := s.RetryAfter(time.Minute); d != 3*time.Second {
+		t.Errorf("want 3s, have %v", d)
+	}
+	if d := (queue.Stats{Len: 10}).RetryAfter(time.Minute); d != time.Second {
+		t.Errorf("unknown drain rate: want 1s, have %v", d)
+	}
+	s.DequeueEvery = time.Hour
+	if d := s.RetryAfter(time.Minute); d != time.Minute {
+		t.Errorf("want cap 1m, have %v", d)
+	}
+}
+
+func TestEnqueueHandler(t *testing.T) {
+	q := queue.NewBounded[string](1)
+	h := queue.RejectWhenFull(q, queue.EnqueueHandler(q, func(r *http.Request) (string, error) {
+		return r.URL.Query().Get("name"), nil
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs?name=a", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("want 202, have %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs?name=b", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want 503, have %d", rec.Code)
+	}
+	if ra := rec.Header().Get("Retry-After"); ra != "1" {
+		t.Errorf("want Retry-After 1, have %q", ra)
+	}
+}
+
+// An unbuffered queue is never "full" by depth, the handler's TryEnqueue
+// decides: 202 while a worker waits, 503 otherwise.
+func TestRejectWhenFull_Unbuffered(t *testing.T) {
+	q := queue.NewBounded[string](0)
+	if q.Stats().Full() {
+		t.Fatal("an empty unbuffered queue must not be full")
+	}
+	h := queue.RejectWhenFull(q, queue.EnqueueHandler(q, func(r *http.Request) (string, error) {
+		return "job", nil
+	}))
+
+	taken := make(chan string)
+	go func() {
+		job, _ := q.Dequeue(context.Background())
+		taken <- job
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", nil))
+		if rec.Code == http.StatusAccepted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want 202 with a waiting worker, have %d", rec.Code)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	<-taken
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want 503 without a worker, have %d", rec.Code)
+	}
+}
@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of queue depth and counters.
+type Stats struct {
+	// Len is the number of queued jobs.
+	Len int
+	// Cap is the queue capacity.
+	Cap int
+	// Enqueued is the number of accepted jobs.
+	Enqueued uint64
+	// Dequeued is the number of jobs taken by consumers.
+	Dequeued uint64
+	// Rejected is the number of jobs refused because the queue was full or the
+	// producer gave up waiting for a free slot.
+	Rejected uint64
+	// DequeueEvery is the moving average of the time between two dequeues,
+	// i.e. the inverse of the drain rate. Zero until two jobs were dequeued.
+	DequeueEvery time.Duration
+}
+
+// Full reports whether there is no free slot. A queue with capacity 0 has no
+// slots, a job is handed straight to a waiting worker, so its depth tells
+// nothing and Full is false; only TryEnqueue knows whether a worker waits.
+func (s Stats) Full() bool {
+	return s.Cap > 0 && s.Len >= s.Cap
+}
+
+// RetryAfter estimates how long a rejected producer should wait: the time
+// needed to drain the current backlog at the observed rate, rounded up to
+// whole seconds, at least one second and at most max.
+func (s Stats) RetryAfter(max time.Duration) time.Duration {
+	d := time.Duration(s.Len) * s.DequeueEvery
+	secs := math.Ceil(d.Seconds())
+	if secs < 1 {
+		secs = 1
+	}
+	d = time.Duration(secs) * time.Second
+	if max > 0 && d > max {
+		d = max
+	}
+	return d
+}
+
+// drainMeter tracks the number of dequeues and an exponentially weighted
+// moving average of the interval between them.
+type drainMeter struct {
+	mu    sync.Mutex
+	n     uint64
+	last  time.Time
+	avgNs float64
+}
+
+// ewmaWeight is the weight of the newest sample, ~ the last 10 dequeues matter
+const ewmaWeight = 0.2
+
+func (m *drainMeter) tick() {
+	now := time.Now()
+	m.mu.Lock()
+	if m.n > 0 {
+		sample := float64(now.Sub(m.last))
+		if m.avgNs == 0 {
+			m.avgNs = sample
+		} else {
+			m.avgNs += ewmaWeight * (sample - m.avgNs)
+		}
+	}
+	m.n++
+	m.last = now
+	m.mu.Unlock()
+}
+
+func (m *drainMeter) count() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.n
+}
+
+func (m *drainMeter) interval() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return time.Duration(m.avgNs)
+}
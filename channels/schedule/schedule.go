@@ -0,0 +1,428 @@
+// Package schedule runs jobs later or on a schedule: delayed jobs
+// (EnqueueAt, EnqueueAfter) and recurring cron-style entries are kept in a
+// timer heap and submitted to a worker pool (see channels/pool) when due.
+//
+// The pool processes *Task values; Handler adapts an ordinary handler and
+// reports the end of every run, which the overlap policies of recurring
+// entries rely on.
+//
+// Example:
+//
+//	p := pool.New(ctx, schedule.Handler(handle), pool.Config[*schedule.Task[Job]]{Workers: 4})
+//	s := schedule.New(ctx, p.Submit, schedule.Config[Job]{})
+//
+//	s.EnqueueAfter(Job{ID: 1}, time.Minute)
+//	s.Add(schedule.Entry[Job]{
+//		Name:     "report",
+//		Schedule: schedule.MustParseCron("0 9 * * 1-5"),
+//		Job:      func(at time.Time) Job { return Job{Report: at} },
+//		Overlap:  schedule.Skip,
+//	})
+//
+//	// on shutdown
+//	delayed := s.Stop()
+//	p.Shutdown(shutdownCtx, pool.Drain)
+package schedule
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/pool"
+	"github.com/r3code/go-useful-snippets/channels/queue"
+)
+
+// ErrDuplicateEntry is returned by Add for an entry name already in use.
+var ErrDuplicateEntry = errors.New("schedule: duplicate entry")
+
+// Overlap decides what happens when a recurring entry is due while its
+// previous run has not finished yet.
+type Overlap int
+
+const (
+	// Skip drops the run, the entry runs again at its next time.
+	Skip Overlap = iota
+	// Queue delays the run until the previous one finishes. Runs due in the
+	// meantime wait in order, so an entry slower than its schedule falls
+	// further and further behind.
+	Queue
+	// Allow submits the run anyway, runs of the entry may execute
+	// concurrently.
+	Allow
+)
+
+// Entry is a recurring job.
+type Entry[T any] struct {
+	// Name identifies the entry for Remove and in Task.Entry.
+	Name string
+	// Schedule gives the run times, e.g. Every(time.Minute) or a Cron.
+	Schedule Schedule
+	// Job creates the job of the run scheduled at the given time.
+	Job func(at time.Time) T
+	// Overlap is the policy for runs due while a previous one is running,
+	// Skip by default.
+	Overlap Overlap
+}
+
+// Task is a job submitted by the scheduler.
+type Task[T any] struct {
+	Job T
+	// Entry is the name of the recurring entry, empty for delayed jobs.
+	Entry string
+	// At is the time the job was scheduled for.
+	At time.Time
+
+	once sync.Once
+	done func()
+}
+
+// Done reports the end of the run. Handler calls it; a handler written for
+// *Task directly must call it too, otherwise the entry is considered
+// running forever. Only the first call counts, so with pool retries the run
+// ends with the first attempt.
+func (t *Task[T]) Done() {
+	t.once.Do(func() {
+		if t.done != nil {
+			t.done()
+		}
+	})
+}
+
+// Handler adapts h to process the tasks of a Scheduler.
+func Handler[T any](h pool.Handler[T]) pool.Handler[*Task[T]] {
+	return func(ctx context.Context, t *Task[T]) error {
+		defer t.Done()
+		return h(ctx, t.Job)
+	}
+}
+
+// Config holds the scheduler settings. The zero value is usable.
+type Config[T any] struct {
+	// Clock is the time source, the system clock by default.
+	Clock Clock
+	// OnError is called when a due job could not be submitted.
+	OnError func(job T, err error)
+	// OnSkip is called for runs dropped by the Skip policy. Like OnError it
+	// is called outside the scheduler lock and may use the Scheduler.
+	OnSkip func(entry string, at time.Time)
+}
+
+// Scheduler keeps jobs until they are due and submits them.
+type Scheduler[T any] struct {
+	submit  func(ctx context.Context, t *Task[T]) error
+	clock   Clock
+	onError func(job T, err error)
+	onSkip  func(entry string, at time.Time)
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	changed chan struct{}
+
+	mu      sync.Mutex
+	items   timerHeap[T]
+	seq     uint64
+	entries map[string]*entry[T]
+	stopped bool
+}
+
+type entry[T any] struct {
+	Entry[T]
+	running int
+	waiting []time.Time // runs delayed by the Queue policy
+	removed bool
+}
+
+// New starts a scheduler submitting due jobs with submit, usually
+// Pool.Submit. submit is called from a single goroutine, while it blocks
+// (e.g. on a full pool queue) later jobs wait. The scheduler stops on Stop
+// or when ctx is cancelled.
+func New[T any](ctx context.Context, submit func(ctx context.Context, t *Task[T]) error, cfg Config[T]) *Scheduler[T] {
+	if cfg.Clock == nil {
+		cfg.Clock = realClock{}
+	}
+	s := &Scheduler[T]{
+		submit:  submit,
+		clock:   cfg.Clock,
+		onError: cfg.OnError,
+		onSkip:  cfg.OnSkip,
+		done:    make(chan struct{}),
+		changed: make(chan struct{}, 1),
+		entries: make(map[string]*entry[T]),
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	go s.loop()
+	return s
+}
+
+// EnqueueAt schedules job to be submitted at the given time, at once if the
+// time has passed. Returns queue.ErrClosed after Stop.
+func (s *Scheduler[T]) EnqueueAt(job T, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return queue.ErrClosed
+	}
+	s.push(&item[T]{at: at, job: job})
+	return nil
+}
+
+// EnqueueAfter schedules job to be submitted after d.
+func (s *Scheduler[T]) EnqueueAfter(job T, d time.Duration) error {
+	return s.EnqueueAt(job, s.clock.Now().Add(d))
+}
+
+// Add registers a recurring entry, its first run is the schedule's next time
+// after now.
+func (s *Scheduler[T]) Add(e Entry[T]) error {
+	if e.Schedule == nil || e.Job == nil {
+		return fmt.Errorf("schedule: entry %q: Schedule and Job are required", e.Name)
+	}
+	now := s.clock.Now()
+	next := nextRun(e.Schedule, now, now)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return queue.ErrClosed
+	}
+	if _, ok := s.entries[e.Name]; ok {
+		return fmt.Errorf("%w: %q", ErrDuplicateEntry, e.Name)
+	}
+	en := &entry[T]{Entry: e}
+	s.entries[e.Name] = en
+	s.scheduleNext(en, next)
+	return nil
+}
+
+// Remove unregisters the entry, runs already submitted are not affected.
+// Returns false if there is no such entry.
+func (s *Scheduler[T]) Remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	en, ok := s.entries[name]
+	if ok {
+		en.removed = true
+		delete(s.entries, name)
+	}
+	return ok
+}
+
+// Len returns the number of delayed jobs not submitted yet.
+func (s *Scheduler[T]) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, it := range s.items {
+		if it.entry == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Stop stops the scheduler and returns the delayed jobs that were not
+// submitted, in due order. A submit blocked at the moment sees a cancelled
+// context.
+func (s *Scheduler[T]) Stop() []T {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	s.cancel()
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var left []T
+	for s.items.Len() > 0 {
+		it := heap.Pop(&s.items).(*item[T])
+		if it.entry == nil {
+			left = append(left, it.job)
+		}
+	}
+	return left
+}
+
+func (s *Scheduler[T]) loop() {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		var wake <-chan time.Time
+		var timer Timer
+		if s.items.Len() > 0 {
+			timer = s.clock.NewTimer(s.items[0].at.Sub(s.clock.Now()))
+			wake = timer.C()
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-wake:
+			s.fire()
+		case <-s.changed:
+		case <-s.ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if s.ctx.Err() != nil {
+			s.mu.Lock()
+			s.stopped = true
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// fire submits every due item. User code (Schedule.Next, Job, submit and
+// the callbacks) runs outside s.mu, so it may call the scheduler.
+func (s *Scheduler[T]) fire() {
+	for s.ctx.Err() == nil {
+		s.mu.Lock()
+		now := s.clock.Now()
+		if s.items.Len() == 0 || s.items[0].at.After(now) {
+			s.mu.Unlock()
+			return
+		}
+		it := heap.Pop(&s.items).(*item[T])
+		s.mu.Unlock()
+
+		var next time.Time
+		if it.entry != nil && !it.waited {
+			next = nextRun(it.entry.Schedule, it.at, now)
+		}
+		s.mu.Lock()
+		task, skipped := s.due(it, next)
+		s.mu.Unlock()
+		if skipped && s.onSkip != nil {
+			s.onSkip(it.entry.Name, it.at)
+		}
+		if task == nil {
+			continue
+		}
+		if it.entry != nil {
+			task.Job = it.entry.Job(task.At)
+		}
+		if err := s.submit(s.ctx, task); err != nil {
+			if task.done != nil {
+				task.Done()
+			}
+			if s.onError != nil {
+				s.onError(task.Job, err)
+			}
+		}
+	}
+}
+
+// due turns a due item into a task, applying the overlap policy to
+// recurring entries; next is the entry's following run time. It returns nil
+// when there is nothing to submit, skipped reports a run dropped by the Skip
+// policy. The job of an entry's task is created by the caller outside the
+// lock. Called with s.mu held.
+func (s *Scheduler[T]) due(it *item[T], next time.Time) (task *Task[T], skipped bool) {
+	en := it.entry
+	if en == nil {
+		return &Task[T]{Job: it.job, At: it.at}, false
+	}
+	if en.removed {
+		return nil, false
+	}
+	// a run keeps its place in waiting until released, so that regular runs
+	// due in the meantime queue up behind it
+	at := it.at
+	if it.waited {
+		at = en.waiting[0]
+		en.waiting = en.waiting[1:]
+	} else {
+		s.scheduleNext(en, next)
+		if en.running > 0 || len(en.waiting) > 0 {
+			switch en.Overlap {
+			case Skip:
+				return nil, true
+			case Queue:
+				en.waiting = append(en.waiting, it.at)
+				return nil, false
+			}
+		}
+	}
+	en.running++
+	task = &Task[T]{Entry: en.Name, At: at}
+	task.done = func() { s.finish(en) }
+	return task, false
+}
+
+// finish ends a run of en and releases the next waiting one.
+func (s *Scheduler[T]) finish(en *entry[T]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	en.running--
+	if en.running > 0 || len(en.waiting) == 0 || en.removed || s.stopped {
+		return
+	}
+	// submitted by the loop: the handler calling finish may run on the
+	// very pool whose queue is full
+	s.push(&item[T]{at: s.clock.Now(), entry: en, waited: true})
+}
+
+// scheduleNext pushes the run of en at next, if any. Called with s.mu held.
+func (s *Scheduler[T]) scheduleNext(en *entry[T], next time.Time) {
+	if next.IsZero() {
+		return
+	}
+	s.push(&item[T]{at: next, entry: en})
+}
+
+// nextRun returns the run of sched following the run at prev, zero when
+// there is none. Runs missed while the scheduler was late are not caught up.
+func nextRun(sched Schedule, prev, now time.Time) time.Time {
+	next := sched.Next(prev)
+	if !next.IsZero() && !next.After(now) {
+		next = sched.Next(now)
+	}
+	return next
+}
+
+// push adds it to the heap and wakes the loop. Called with s.mu held.
+func (s *Scheduler[T]) push(it *item[T]) {
+	s.seq++
+	it.seq = s.seq
+	heap.Push(&s.items, it)
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+type item[T any] struct {
+	at    time.Time
+	seq   uint64 // keeps FIFO order of items due at the same time
+	job   T
+	entry *entry[T]
+	// waited marks the release of the first run in entry.waiting
+	waited bool
+}
+
+type timerHeap[T any] []*item[T]
+
+func (h timerHeap[T]) Len() int { return len(h) }
+
+func (h timerHeap[T]) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+
+func (h timerHeap[T]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *timerHeap[T]) Push(x any) { *h = append(*h, x.(*item[T])) }
+
+func (h *timerHeap[T]) Pop() any {
+	old := *h
+	it := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return it
+}
@@ -0,0 +1,276 @@
+package schedule_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/pool"
+	"github.com/r3code/go-useful-snippets/channels/schedule"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// recorder is a submit func passing the tasks to a channel.
+type recorder struct {
+	tasks chan *schedule.Task[string]
+}
+
+func newRecorder() *recorder {
+	return &recorder{tasks: make(chan *schedule.Task[string], 100)}
+}
+
+func (r *recorder) submit(_ context.Context, t *schedule.Task[string]) error {
+	r.tasks <- t
+	return nil
+}
+
+func (r *recorder) next(t *testing.T) *schedule.Task[string] {
+	t.Helper()
+	select {
+	case task := <-r.tasks:
+		return task
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for a submitted task")
+		return nil
+	}
+}
+
+func (r *recorder) none(t *testing.T) {
+	t.Helper()
+	select {
+	case task := <-r.tasks:
+		t.Fatalf("unexpected task %q at %v", task.Job, task.At)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestEnqueueAfter(t *testing.T) {
+	clock := schedule.NewFakeClock(start)
+	rec := newRecorder()
+	s := schedule.New(context.Background(), rec.submit, schedule.Config[string]{Clock: clock})
+	defer s.Stop()
+
+	s.EnqueueAfter("b", 2*time.Minute)
+	s.EnqueueAfter("a", time.Minute)
+	s.EnqueueAt("c", start.Add(2*time.Minute))
+
+	clock.BlockUntil(1)
+	rec.none(t)
+	clock.Advance(time.Minute)
+	if got := rec.next(t); got.Job != "a" || !got.At.Equal(start.Add(time.Minute)) {
+		t.Errorf("got %q at %v, want a at +1m", got.Job, got.At)
+	}
+	rec.none(t)
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	// equal times keep the enqueue order
+	if got := rec.next(t); got.Job != "b" {
+		t.Errorf("got %q, want b", got.Job)
+	}
+	if got := rec.next(t); got.Job != "c" {
+		t.Errorf("got %q, want c", got.Job)
+	}
+	if n := s.Len(); n != 0 {
+		t.Errorf("Len() = %d, want 0", n)
+	}
+}
+
+func TestStopReturnsDelayed(t *testing.T) {
+	clock := schedule.NewFakeClock(start)
+	s := schedule.New(context.Background(), newRecorder().submit, schedule.Config[string]{Clock: clock})
+	s.EnqueueAfter("later", time.Hour)
+	s.EnqueueAfter("soon", time.Minute)
+	s.Add(schedule.Entry[string]{
+		Name:     "tick",
+		Schedule: schedule.Every(time.Minute),
+		Job:      func(time.Time) string { return "tick" },
+	})
+	left := s.Stop()
+	if len(left) != 2 || left[0] != "soon" || left[1] != "later" {
+		t.Errorf("Stop() = %v, want [soon later]", left)
+	}
+	if err := s.EnqueueAfter("x", 0); err == nil {
+		t.Error("want an error after Stop")
+	}
+}
+
+func addTick(t *testing.T, s *schedule.Scheduler[string], overlap schedule.Overlap) {
+	t.Helper()
+	err := s.Add(schedule.Entry[string]{
+		Name:     "tick",
+		Schedule: schedule.Every(time.Minute),
+		Job:      func(at time.Time) string { return at.Sub(start).String() },
+		Overlap:  overlap,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tick advances the clock to the next run of the tick entry.
+func tick(clock *schedule.FakeClock) {
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+}
+
+func TestOverlapSkip(t *testing.T) {
+	clock := schedule.NewFakeClock(start)
+	rec := newRecorder()
+	skipped := make(chan time.Time, 10)
+	s := schedule.New(context.Background(), rec.submit, schedule.Config[string]{
+		Clock:  clock,
+		OnSkip: func(_ string, at time.Time) { skipped <- at },
+	})
+	defer s.Stop()
+	addTick(t, s, schedule.Skip)
+
+	tick(clock)
+	first := rec.next(t)
+	if first.Job != "1m0s" || first.Entry != "tick" {
+		t.Errorf("got %q of %q, want 1m0s of tick", first.Job, first.Entry)
+	}
+	tick(clock)
+	select {
+	case at := <-skipped:
+		if !at.Equal(start.Add(2 * time.Minute)) {
+			t.Errorf("skipped run at %v, want +2m", at)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("run was not skipped")
+	}
+	rec.none(t)
+
+	first.Done()
+	tick(clock)
+	if got := rec.next(t); got.Job != "3m0s" {
+		t.Errorf("got %q, want 3m0s", got.Job)
+	}
+}
+
+// OnSkip may call the scheduler: it must not run under the scheduler lock.
+func TestOnSkipCallsScheduler(t *testing.T) {
+	clock := schedule.NewFakeClock(start)
+	rec := newRecorder()
+	var s *schedule.Scheduler[string]
+	removed := make(chan bool, 1)
+	s = schedule.New(context.Background(), rec.submit, schedule.Config[string]{
+		Clock: clock,
+		OnSkip: func(entry string, _ time.Time) {
+			_ = s.Len()
+			_ = s.EnqueueAfter("delayed", time.Hour)
+			removed <- s.Remove(entry)
+		},
+	})
+	defer s.Stop()
+	addTick(t, s, schedule.Skip)
+
+	tick(clock)
+	rec.next(t)
+	tick(clock)
+	select {
+	case ok := <-removed:
+		if !ok {
+			t.Error("Remove from OnSkip did not find the entry")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnSkip deadlocks on the scheduler")
+	}
+	if n := s.Len(); n != 1 {
+		t.Errorf("want 1 delayed job, have %d", n)
+	}
+}
+
+func TestOverlapQueue(t *testing.T) {
+	clock := schedule.NewFakeClock(start)
+	rec := newRecorder()
+	s := schedule.New(context.Background(), rec.submit, schedule.Config[string]{Clock: clock})
+	defer s.Stop()
+	addTick(t, s, schedule.Queue)
+
+	tick(clock)
+	first := rec.next(t)
+	tick(clock)
+	tick(clock)
+	rec.none(t)
+
+	// the delayed runs keep their times and start one after another
+	first.Done()
+	second := rec.next(t)
+	if second.Job != "2m0s" {
+		t.Errorf("got %q, want 2m0s", second.Job)
+	}
+	rec.none(t)
+	second.Done()
+	if got := rec.next(t); got.Job != "3m0s" {
+		t.Errorf("got %q, want 3m0s", got.Job)
+	}
+}
+
+func TestOverlapAllow(t *testing.T) {
+	clock := schedule.NewFakeClock(start)
+	rec := newRecorder()
+	s := schedule.New(context.Background(), rec.submit, schedule.Config[string]{Clock: clock})
+	defer s.Stop()
+	addTick(t, s, schedule.Allow)
+
+	tick(clock)
+	rec.next(t)
+	tick(clock)
+	if got := rec.next(t); got.Job != "2m0s" {
+		t.Errorf("got %q, want 2m0s", got.Job)
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	clock := schedule.NewFakeClock(start)
+	rec := newRecorder()
+	s := schedule.New(context.Background(), rec.submit, schedule.Config[string]{Clock: clock})
+	defer s.Stop()
+	addTick(t, s, schedule.Allow)
+	if err := s.Add(schedule.Entry[string]{
+		Name:     "tick",
+		Schedule: schedule.Every(time.Second),
+		Job:      func(time.Time) string { return "" },
+	}); err == nil {
+		t.Error("want an error for a duplicate name")
+	}
+	if !s.Remove("tick") {
+		t.Fatal("Remove() = false")
+	}
+	tick(clock)
+	rec.none(t)
+}
+
+func TestWithPool(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var got []string
+	done := make(chan struct{})
+	p := pool.New(ctx, schedule.Handler(func(_ context.Context, job string) error {
+		mu.Lock()
+		got = append(got, job)
+		if len(got) == 2 {
+			close(done)
+		}
+		mu.Unlock()
+		return nil
+	}), pool.Config[*schedule.Task[string]]{Workers: 1})
+	s := schedule.New(ctx, p.Submit, schedule.Config[string]{})
+
+	s.EnqueueAfter("second", 20*time.Millisecond)
+	s.EnqueueAfter("first", 5*time.Millisecond)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the delayed jobs")
+	}
+	s.Stop()
+	p.Stop()
+	p.Wait()
+	if got[0] != "first" || got[1] != "second" {
+		t.Errorf("got %v, want [first second]", got)
+	}
+}
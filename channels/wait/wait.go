@@ -0,0 +1,71 @@
+// Package wait contains helpers for waiting on a sync.WaitGroup or a channel
+// with a timeout or a context, e.g. to bound graceful shutdown of workers.
+//
+// Example:
+//
+//	p.Stop()
+//	if !wait.WaitTimeout(&wg, 5*time.Second) {
+//		log.Println("workers did not stop in 5s")
+//	}
+package wait
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WaitTimeout waits for wg for at most d. Returns true if wg finished in time.
+//
+// sync.WaitGroup cannot be waited on with a select, so a helper goroutine
+// calls wg.Wait(). After a timeout it stays until the group is done and then
+// exits, it never outlives the group.
+func WaitTimeout(wg *sync.WaitGroup, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-done(wg):
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// WaitContext waits for wg until ctx is done. Returns nil if wg finished,
+// otherwise ctx.Err(). See WaitTimeout about the helper goroutine.
+func WaitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	select {
+	case <-done(wg):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Receive reads a value from ch or returns ctx.Err() when ctx is done first.
+// ok is false when ch is closed, like in `v, ok := <-ch`.
+func Receive[T any](ctx context.Context, ch <-chan T) (v T, ok bool, err error) {
+	select {
+	case v, ok = <-ch:
+		return v, ok, nil
+	case <-ctx.Done():
+		return v, false, ctx.Err()
+	}
+}
+
+// ReceiveTimeout reads a value from ch waiting at most d.
+// Returns context.DeadlineExceeded on timeout.
+func ReceiveTimeout[T any](ch <-chan T, d time.Duration) (v T, ok bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return Receive(ctx, ch)
+}
+
+func done(wg *sync.WaitGroup) <-chan struct{} {
+	ch := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
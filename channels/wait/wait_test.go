@@ -0,0 +1,68 @@
+package wait_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r3code/go-useful-snippets/channels/internal/leaktest"
+	"github.com/r3code/go-useful-snippets/channels/wait"
+)
+
+func TestWaitTimeout_Done(t *testing.T) {
+	defer leaktest.Check(t)()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		wg.Done()
+	}()
+	if !wait.WaitTimeout(&wg, time.Second) {
+		t.Error("want true when the group finishes in time")
+	}
+}
+
+func TestWaitTimeout_Expired(t *testing.T) {
+	defer leaktest.Check(t)()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	if wait.WaitTimeout(&wg, 10*time.Millisecond) {
+		t.Error("want false on timeout")
+	}
+	// the helper goroutine must exit once the group is done
+	wg.Done()
+}
+
+func TestWaitContext(t *testing.T) {
+	defer leaktest.Check(t)()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := wait.WaitContext(ctx, &wg); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, have %v", err)
+	}
+	wg.Done()
+	if err := wait.WaitContext(context.Background(), &wg); err != nil {
+		t.Errorf("want nil, have %v", err)
+	}
+}
+
+func TestReceive(t *testing.T) {
+	defer leaktest.Check(t)()
+	ch := make(chan int, 1)
+	ch <- 42
+	v, ok, err := wait.ReceiveTimeout(ch, time.Second)
+	if v != 42 || !ok || err != nil {
+		t.Errorf("want 42 true nil, have %v %v %v", v, ok, err)
+	}
+	if _, _, err = wait.ReceiveTimeout(ch, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want DeadlineExceeded, have %v", err)
+	}
+	close(ch)
+	if _, ok, err = wait.Receive(context.Background(), ch); ok || err != nil {
+		t.Errorf("closed channel: want false nil, have %v %v", ok, err)
+	}
+}
@@ -1,3 +1,5 @@
+//go:build ignore
+
 
 func worker(jobChan <-chan Job, cancelChan <-chan struct{}) {
     for {
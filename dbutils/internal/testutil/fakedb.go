@@ -0,0 +1,203 @@
+// Package testutil общие помощники модульных тестов dbutils. Пакет
+// импортируется только из тестов.
+package testutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Подставной драйвер database/sql для модульных тестов без Postgres: он
+// записывает выполненные выражения, а поведение задается функциями FakeDB.
+
+const fakeDriverName = "dbutils_fake"
+
+var (
+	fakeDBs    sync.Map // dsn -> *FakeDB
+	fakeDBSeq  int64
+	fakeDriver sync.Once
+)
+
+// FakeDB подставная БД. Функции задаются до первого запроса, вызываются
+// конкурентно из разных соединений пула.
+type FakeDB struct {
+	mu  sync.Mutex
+	log []string
+	// Exec вызывается для каждого Exec, может вернуть ошибку. tx - текущая
+	// транзакция соединения, nil вне транзакции
+	Exec func(ctx context.Context, tx *FakeTx, query string, args []driver.NamedValue) error
+	// Query возвращает колонки и строки результата запроса
+	Query func(ctx context.Context, tx *FakeTx, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error)
+	// Commit вызывается перед фиксацией транзакции
+	Commit func() error
+}
+
+// NewFakeDB открывает пул не более чем из conns соединений к новой
+// подставной БД, name - имя БД для сообщений, например имя теста.
+func NewFakeDB(name string, conns int) (*sqlx.DB, *FakeDB) {
+	fakeDriver.Do(func() { sql.Register(fakeDriverName, fakeDriverImpl{}) })
+	fdb := &FakeDB{}
+	dsn := fmt.Sprintf("%s-%d", name, atomic.AddInt64(&fakeDBSeq, 1))
+	fakeDBs.Store(dsn, fdb)
+	db := sqlx.MustOpen(fakeDriverName, dsn)
+	db.SetMaxOpenConns(conns)
+	return db, fdb
+}
+
+// Record добавляет s в лог выражений.
+func (f *FakeDB) Record(s string) {
+	f.mu.Lock()
+	f.log = append(f.log, s)
+	f.mu.Unlock()
+}
+
+// Statements возвращает выполненные выражения через "; ".
+func (f *FakeDB) Statements() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return strings.Join(f.log, "; ")
+}
+
+// FakeTx транзакция подставной БД.
+type FakeTx struct {
+	conn  *fakeConn
+	onEnd []func(committed bool)
+}
+
+// OnEnd регистрирует fn, которая вызывается при завершении транзакции,
+// committed - транзакция зафиксирована.
+func (tx *FakeTx) OnEnd(fn func(committed bool)) {
+	tx.onEnd = append(tx.onEnd, fn)
+}
+
+// Commit вызывает FakeDB.Commit и фиксирует транзакцию.
+func (tx *FakeTx) Commit() error {
+	db := tx.conn.db
+	if db.Commit != nil {
+		if err := db.Commit(); err != nil {
+			db.Record("COMMIT failed")
+			tx.end(false)
+			return err
+		}
+	}
+	db.Record("COMMIT")
+	tx.end(true)
+	return nil
+}
+
+// Rollback откатывает транзакцию.
+func (tx *FakeTx) Rollback() error {
+	tx.conn.db.Record("ROLLBACK")
+	tx.end(false)
+	return nil
+}
+
+func (tx *FakeTx) end(committed bool) {
+	tx.conn.tx = nil
+	for _, fn := range tx.onEnd {
+		fn(committed)
+	}
+}
+
+type fakeDriverImpl struct{}
+
+func (fakeDriverImpl) Open(dsn string) (driver.Conn, error) {
+	v, ok := fakeDBs.Load(dsn)
+	if !ok {
+		return nil, fmt.Errorf("unknown fake db %q", dsn)
+	}
+	return &fakeConn{db: v.(*FakeDB)}, nil
+}
+
+type fakeConn struct {
+	db *FakeDB
+	tx *FakeTx
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.db.Record("BEGIN")
+	c.tx = &FakeTx{conn: c}
+	return c.tx, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.Record(query)
+	if c.db.Exec != nil {
+		if err := c.db.Exec(ctx, c.tx, query, args); err != nil {
+			return nil, err
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.Record(query)
+	if c.db.Query == nil {
+		return &fakeRows{}, nil
+	}
+	cols, vals, err := c.db.Query(ctx, c.tx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{cols: cols, vals: vals}, nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	out := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		out[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return out
+}
+
+type fakeRows struct {
+	cols []string
+	vals [][]driver.Value
+	pos  int
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.vals) {
+		return io.EOF
+	}
+	copy(dest, r.vals[r.pos])
+	r.pos++
+	return nil
+}
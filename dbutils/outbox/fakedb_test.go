@@ -0,0 +1,227 @@
+package outbox_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/r3code/go-useful-snippets/dbutils/internal/testutil"
+)
+
+// Таблица outbox в памяти поверх подставного драйвера testutil: понимает
+// только запросы пакета. Изменения транзакции отменяются при откате,
+// строки, выбранные FOR UPDATE SKIP LOCKED, заблокированы до конца
+// транзакции. Часы БД (now()) можно сдвинуть относительно часов приложения
+// через setSkew.
+
+type fakeRow struct {
+	id          int64
+	topic       string
+	key         string
+	payload     []byte
+	createdAt   time.Time
+	attempts    int64
+	nextAttempt time.Time
+	lastError   string
+	sent        bool
+	failed      bool
+}
+
+type fakeOutbox struct {
+	mu    sync.Mutex
+	seq   int64
+	rows  []*fakeRow
+	locks map[int64]*testutil.FakeTx
+	txs   map[*testutil.FakeTx]*fakeTxState
+	skew  time.Duration
+}
+
+// fakeTxState изменения и блокировки транзакции.
+type fakeTxState struct {
+	undo   []func()
+	locked []int64
+}
+
+// newFakeDB открывает пул соединений к новой БД с пустой таблицей outbox.
+func newFakeDB(t *testing.T, conns int) (*sqlx.DB, *fakeOutbox) {
+	db, fdb := testutil.NewFakeDB(t.Name(), conns)
+	f := &fakeOutbox{
+		locks: map[int64]*testutil.FakeTx{},
+		txs:   map[*testutil.FakeTx]*fakeTxState{},
+	}
+	fdb.Exec = f.exec
+	fdb.Query = f.query
+	return db, f
+}
+
+// row возвращает копию строки по id.
+func (f *fakeOutbox) row(id int64) fakeRow {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.rows {
+		if r.id == id {
+			return *r
+		}
+	}
+	return fakeRow{}
+}
+
+func (f *fakeOutbox) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.rows)
+}
+
+// setSkew сдвигает часы БД относительно часов приложения.
+func (f *fakeOutbox) setSkew(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.skew = d
+}
+
+// now значение now() в БД, f.mu захвачен.
+func (f *fakeOutbox) now() time.Time {
+	return time.Now().Add(f.skew)
+}
+
+func (f *fakeOutbox) exec(ctx context.Context, tx *testutil.FakeTx, query string, args []driver.NamedValue) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO outbox (topic, key, payload)"):
+		f.seq++
+		r := &fakeRow{
+			id:          f.seq,
+			topic:       args[0].Value.(string),
+			key:         args[1].Value.(string),
+			payload:     args[2].Value.([]byte),
+			createdAt:   f.now(),
+			nextAttempt: f.now(),
+		}
+		f.rows = append(f.rows, r)
+		f.undo(tx, func() { f.remove(r.id) })
+	case strings.HasPrefix(query, "UPDATE outbox SET sent_at = now()"):
+		f.update(tx, args[0].Value.(int64), func(r *fakeRow) { r.sent = true })
+	case strings.HasPrefix(query, "UPDATE outbox SET attempts = ?, last_error = ?, next_attempt_at = now() + CAST(? AS interval)"):
+		var us int64
+		if _, err := fmt.Sscanf(args[2].Value.(string), "%d microseconds", &us); err != nil {
+			return fmt.Errorf("bad interval %q: %v", args[2].Value, err)
+		}
+		f.update(tx, args[3].Value.(int64), func(r *fakeRow) {
+			r.attempts = args[0].Value.(int64)
+			r.lastError = args[1].Value.(string)
+			r.nextAttempt = f.now().Add(time.Duration(us) * time.Microsecond)
+		})
+	case strings.HasPrefix(query, "UPDATE outbox SET attempts = ?, last_error = ?, failed_at = now()"):
+		f.update(tx, args[2].Value.(int64), func(r *fakeRow) {
+			r.attempts = args[0].Value.(int64)
+			r.lastError = args[1].Value.(string)
+			r.failed = true
+		})
+	default:
+		return fmt.Errorf("unexpected statement %q", query)
+	}
+	return nil
+}
+
+func (f *fakeOutbox) query(ctx context.Context, tx *testutil.FakeTx, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(query, "SELECT id, topic, key, payload, attempts, created_at FROM outbox") ||
+		!strings.HasSuffix(query, "FOR UPDATE SKIP LOCKED") {
+		return nil, nil, fmt.Errorf("unexpected query %q", query)
+	}
+	limit := args[0].Value.(int64)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.now()
+	var vals [][]driver.Value
+	for _, r := range f.rows {
+		if int64(len(vals)) >= limit {
+			break
+		}
+		if r.sent || r.failed || r.nextAttempt.After(now) {
+			continue
+		}
+		if owner, ok := f.locks[r.id]; ok && owner != tx {
+			continue // SKIP LOCKED
+		}
+		if st := f.state(tx); st != nil {
+			f.locks[r.id] = tx
+			st.locked = append(st.locked, r.id)
+		}
+		vals = append(vals, []driver.Value{r.id, r.topic, r.key, r.payload, r.attempts, r.createdAt})
+	}
+	return []string{"id", "topic", "key", "payload", "attempts", "created_at"}, vals, nil
+}
+
+// update изменяет строку и запоминает, как отменить изменение, f.mu
+// захвачен.
+func (f *fakeOutbox) update(tx *testutil.FakeTx, id int64, fn func(r *fakeRow)) {
+	for _, r := range f.rows {
+		if r.id == id {
+			old := *r
+			fn(r)
+			f.undo(tx, func() { *r = old })
+			return
+		}
+	}
+}
+
+// undo запоминает отмену изменения транзакции tx, f.mu захвачен.
+func (f *fakeOutbox) undo(tx *testutil.FakeTx, fn func()) {
+	if st := f.state(tx); st != nil {
+		st.undo = append(st.undo, fn)
+	}
+}
+
+// state возвращает состояние транзакции tx, nil вне транзакции. f.mu
+// захвачен.
+func (f *fakeOutbox) state(tx *testutil.FakeTx) *fakeTxState {
+	if tx == nil {
+		return nil
+	}
+	st, ok := f.txs[tx]
+	if !ok {
+		st = &fakeTxState{}
+		f.txs[tx] = st
+		tx.OnEnd(func(committed bool) { f.end(tx, committed) })
+	}
+	return st
+}
+
+// end откатывает изменения незафиксированной транзакции и снимает ее
+// блокировки.
+func (f *fakeOutbox) end(tx *testutil.FakeTx, committed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	st := f.txs[tx]
+	delete(f.txs, tx)
+	if !committed {
+		for i := len(st.undo) - 1; i >= 0; i-- {
+			st.undo[i]()
+		}
+	}
+	for _, id := range st.locked {
+		delete(f.locks, id)
+	}
+}
+
+func (f *fakeOutbox) remove(id int64) {
+	for i, r := range f.rows {
+		if r.id == id {
+			f.rows = append(f.rows[:i], f.rows[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Package outbox реализует transactional outbox поверх transactor:
+// сообщения записываются в таблицу outbox в той же транзакции, что и
+// изменения данных, а `Relay` доставляет их издателю (брокеру сообщений)
+// после фиксации.
+//
+// Доставка "хотя бы один раз": сообщение может быть опубликовано повторно,
+// если отметка об отправке не была зафиксирована, поэтому получатели должны
+// быть идемпотентны, для этого подходит Message.ID.
+package outbox
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/r3code/go-useful-snippets/dbutils/transactor"
+)
+
+// DefaultTable имя таблицы по умолчанию.
+const DefaultTable = "outbox"
+
+// Schema возвращает DDL таблицы outbox для Postgres.
+func Schema(table string) string {
+	if table == "" {
+		table = DefaultTable
+	}
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+	id bigserial PRIMARY KEY,
+	topic text NOT NULL,
+	key text NOT NULL DEFAULT '',
+	payload bytea NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	attempts integer NOT NULL DEFAULT 0,
+	next_attempt_at timestamptz NOT NULL DEFAULT now(),
+	last_error text,
+	sent_at timestamptz,
+	failed_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS %[1]s_pending_idx ON %[1]s (id) WHERE sent_at IS NULL AND failed_at IS NULL;`, table)
+}
+
+// Message сообщение outbox.
+type Message struct {
+	// ID присваивается БД, его можно использовать для дедупликации
+	ID int64 `db:"id"`
+	// Topic куда публиковать сообщение
+	Topic string `db:"topic"`
+	// Key ключ сообщения, например для выбора партиции
+	Key string `db:"key"`
+	// Payload тело сообщения
+	Payload []byte `db:"payload"`
+	// Attempts число неудачных попыток публикации
+	Attempts int `db:"attempts"`
+	// CreatedAt время добавления в outbox
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Outbox добавляет сообщения в таблицу outbox.
+type Outbox struct {
+	table string
+}
+
+// New создает Outbox для таблицы table, пустое имя - `DefaultTable`.
+// Имя таблицы подставляется в запросы как есть.
+func New(table string) *Outbox {
+	if table == "" {
+		table = DefaultTable
+	}
+	return &Outbox{table: table}
+}
+
+// Append добавляет сообщения в outbox в транзакции tx, они будут
+// опубликованы, только если транзакция зафиксирована. Заполняются Topic,
+// Key и Payload.
+func (o *Outbox) Append(tx transactor.TransactionX, msgs ...Message) error {
+	query := tx.Rebind(fmt.Sprintf("INSERT INTO %s (topic, key, payload) VALUES (?, ?, ?)", o.table))
+	for _, msg := range msgs {
+		if _, err := tx.Exec(query, msg.Topic, msg.Key, msg.Payload); err != nil {
+			return errors.Annotatef(err, "Outbox append to %q failed", msg.Topic)
+		}
+	}
+	return nil
+}
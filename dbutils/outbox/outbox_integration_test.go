@@ -0,0 +1,147 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+
+	"github.com/r3code/go-useful-snippets/dbutils/outbox"
+	"github.com/r3code/go-useful-snippets/dbutils/transactor"
+)
+
+var (
+	dbHost     = flag.String("test.dbhost", "localhost", "Postgres Database host address")
+	dbPort     = flag.Int("test.dbport", 5432, "Postgres Database port number")
+	dbUser     = flag.String("test.dbuser", "test", "Postgres Database user name")
+	dbPassword = flag.String("test.dbpass", "test", "Postgres Database user password")
+	dbName     = flag.String("test.dbname", "test", "Postgres Database name")
+)
+
+const integrationTable = "outbox_integration"
+
+// mustGetTestDB подключается к тестовой базе и создает пустую таблицу
+// outbox по Schema, при ошибках вызывает panic.
+func mustGetTestDB() *sqlx.DB {
+	dbConnStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		*dbHost, uint16(*dbPort), *dbUser, *dbPassword, *dbName, "disable")
+	db := sqlx.MustConnect("postgres", dbConnStr)
+	db.MustExec("DROP TABLE IF EXISTS " + integrationTable)
+	db.MustExec(outbox.Schema(integrationTable))
+	return db
+}
+
+func appendIntegration(t *testing.T, db *sqlx.DB, box *outbox.Outbox, msgs ...outbox.Message) {
+	err := transactor.WithCtxTransaction(context.Background(), nil, db, func(tx transactor.TransactionX) error {
+		return box.Append(tx, msgs...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIntegration_ConcurrentPollsSkipLockedRows(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	db := mustGetTestDB()
+	defer db.Close()
+	box := outbox.New(integrationTable)
+	const total = 10
+	msgs := make([]outbox.Message, total)
+	for i := range msgs {
+		msgs[i] = outbox.Message{Topic: "t", Payload: []byte(fmt.Sprint(i))}
+	}
+	appendIntegration(t, db, box, msgs...)
+
+	// первый Relay держит блокировки своей пачки, пока второй опрашивает
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	first := &recorder{fail: func(msg outbox.Message) error {
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+		return nil
+	}}
+	second := &recorder{}
+	done := make(chan error, 1)
+	go func() {
+		_, err := outbox.NewRelay(db, box, first, outbox.RelayConfig{BatchSize: total / 2}).Poll(context.Background())
+		done <- err
+	}()
+	<-started
+	n, err := outbox.NewRelay(db, box, second, outbox.RelayConfig{BatchSize: total}).Poll(context.Background())
+	close(release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n != total/2 {
+		t.Errorf("second relay must skip locked rows, selected %d", n)
+	}
+
+	seen := map[int64]bool{}
+	for _, msg := range append(first.published(), second.published()...) {
+		if seen[msg.ID] {
+			t.Errorf("message %d published twice", msg.ID)
+		}
+		seen[msg.ID] = true
+	}
+	if len(seen) != total {
+		t.Errorf("want %d messages published, have %d", total, len(seen))
+	}
+}
+
+func TestIntegration_RetryAfterBackoff(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	db := mustGetTestDB()
+	defer db.Close()
+	box := outbox.New(integrationTable)
+	appendIntegration(t, db, box, outbox.Message{Topic: "t", Payload: []byte("x")})
+
+	const backoff = 500 * time.Millisecond
+	failures := 1
+	pub := &recorder{fail: func(msg outbox.Message) error {
+		if failures > 0 {
+			failures--
+			return errors.New("broker down")
+		}
+		return nil
+	}}
+	relay := outbox.NewRelay(db, box, pub, outbox.RelayConfig{InitialBackoff: backoff})
+	if _, err := relay.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var waitUs int64
+	if err := db.Get(&waitUs, "SELECT CAST(EXTRACT(EPOCH FROM next_attempt_at - now()) * 1000000 AS bigint) FROM "+integrationTable); err != nil {
+		t.Fatal(err)
+	}
+	wait := time.Duration(waitUs) * time.Microsecond
+	if wait <= 0 || wait > backoff {
+		t.Errorf("next attempt must be within %v, have %v", backoff, wait)
+	}
+	if n, err := relay.Poll(context.Background()); err != nil || n != 0 {
+		t.Fatalf("message must wait for backoff, selected %d, err %v", n, err)
+	}
+
+	time.Sleep(backoff)
+	if n, err := relay.Poll(context.Background()); err != nil || n != 1 {
+		t.Fatalf("message must be retried after backoff, selected %d, err %v", n, err)
+	}
+	if have := pub.published(); len(have) != 1 {
+		t.Errorf("want 1 message published, have %d", len(have))
+	}
+}
@@ -0,0 +1,307 @@
+package outbox_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/juju/errors"
+
+	"github.com/r3code/go-useful-snippets/dbutils/outbox"
+	"github.com/r3code/go-useful-snippets/dbutils/transactor"
+)
+
+// recorder издатель, запоминающий опубликованные сообщения.
+type recorder struct {
+	mu   sync.Mutex
+	msgs []outbox.Message
+	fail func(msg outbox.Message) error
+}
+
+func (r *recorder) Publish(ctx context.Context, msg outbox.Message) error {
+	if r.fail != nil {
+		if err := r.fail(msg); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	r.msgs = append(r.msgs, msg)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *recorder) published() []outbox.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]outbox.Message(nil), r.msgs...)
+}
+
+func appendMessages(t *testing.T, db *sqlx.DB, box *outbox.Outbox, fnErr error, msgs ...outbox.Message) error {
+	return transactor.WithCtxTransaction(context.Background(), nil, db, func(tx transactor.TransactionX) error {
+		if err := box.Append(tx, msgs...); err != nil {
+			t.Fatal(err)
+		}
+		return fnErr
+	})
+}
+
+func TestRelay_PublishesCommittedMessages(t *testing.T) {
+	db, fdb := newFakeDB(t, 1)
+	defer db.Close()
+	box := outbox.New("")
+	pub := &recorder{}
+	relay := outbox.NewRelay(db, box, pub, outbox.RelayConfig{})
+
+	err := appendMessages(t, db, box, nil,
+		outbox.Message{Topic: "users", Key: "1", Payload: []byte("created")},
+		outbox.Message{Topic: "users", Key: "1", Payload: []byte("renamed")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollbackErr := errors.New("rolled back")
+	err = appendMessages(t, db, box, rollbackErr, outbox.Message{Topic: "users", Payload: []byte("lost")})
+	if errors.Cause(err) != rollbackErr {
+		t.Fatalf("have %v, want %v", err, rollbackErr)
+	}
+	if n := fdb.count(); n != 2 {
+		t.Fatalf("outbox has %d messages, want 2", n)
+	}
+
+	n, err := relay.Poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("polled %d messages, want 2", n)
+	}
+	msgs := pub.published()
+	if len(msgs) != 2 || string(msgs[0].Payload) != "created" || string(msgs[1].Payload) != "renamed" {
+		t.Fatalf("published %+v", msgs)
+	}
+	if msgs[0].ID != 1 || msgs[0].Topic != "users" || msgs[0].Key != "1" {
+		t.Errorf("have %+v", msgs[0])
+	}
+	if !fdb.row(1).sent || !fdb.row(2).sent {
+		t.Error("messages are not marked sent")
+	}
+
+	if n, err = relay.Poll(context.Background()); err != nil || n != 0 {
+		t.Errorf("second poll: have %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestRelay_RetriesWithBackoff(t *testing.T) {
+	db, fdb := newFakeDB(t, 1)
+	defer db.Close()
+	box := outbox.New("")
+	failures := 1
+	pub := &recorder{fail: func(msg outbox.Message) error {
+		if failures > 0 {
+			failures--
+			return errors.New("broker unavailable")
+		}
+		return nil
+	}}
+	var reported []error
+	relay := outbox.NewRelay(db, box, pub, outbox.RelayConfig{
+		InitialBackoff: 50 * time.Millisecond,
+		OnPublishError: func(msg outbox.Message, err error, final bool) {
+			if final {
+				t.Error("the first failure is not final")
+			}
+			reported = append(reported, err)
+		},
+	})
+	if err := appendMessages(t, db, box, nil, outbox.Message{Topic: "users"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := relay.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	row := fdb.row(1)
+	if row.sent || row.attempts != 1 || row.lastError != "broker unavailable" {
+		t.Fatalf("have %+v after a failed attempt", row)
+	}
+	if len(reported) != 1 {
+		t.Errorf("OnPublishError called %d times, want 1", len(reported))
+	}
+	// до следующей попытки сообщение не выбирается
+	if n, err := relay.Poll(context.Background()); err != nil || n != 0 {
+		t.Fatalf("poll during backoff: have %d, %v; want 0, nil", n, err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if n, err := relay.Poll(context.Background()); err != nil || n != 1 {
+		t.Fatalf("poll after backoff: have %d, %v; want 1, nil", n, err)
+	}
+	if msgs := pub.published(); len(msgs) != 1 || msgs[0].Attempts != 1 {
+		t.Errorf("published %+v", msgs)
+	}
+	if !fdb.row(1).sent {
+		t.Error("message is not marked sent")
+	}
+}
+
+// Часы БД отстают от часов приложения на час: срок повторной попытки
+// считается по часам БД, поэтому сообщение выбирается через InitialBackoff,
+// а не через час.
+func TestRelay_BackoffUsesDatabaseClock(t *testing.T) {
+	db, fdb := newFakeDB(t, 1)
+	defer db.Close()
+	fdb.setSkew(-time.Hour)
+	box := outbox.New("")
+	failures := 1
+	pub := &recorder{fail: func(msg outbox.Message) error {
+		if failures > 0 {
+			failures--
+			return errors.New("broker unavailable")
+		}
+		return nil
+	}}
+	relay := outbox.NewRelay(db, box, pub, outbox.RelayConfig{InitialBackoff: 50 * time.Millisecond})
+	if err := appendMessages(t, db, box, nil, outbox.Message{Topic: "users"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := relay.Poll(context.Background()); err != nil || n != 1 {
+		t.Fatalf("first poll: have %d, %v; want 1, nil", n, err)
+	}
+	if n, err := relay.Poll(context.Background()); err != nil || n != 0 {
+		t.Fatalf("poll during backoff: have %d, %v; want 0, nil", n, err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if n, err := relay.Poll(context.Background()); err != nil || n != 1 {
+		t.Fatalf("poll after backoff: have %d, %v; want 1, nil", n, err)
+	}
+	if !fdb.row(1).sent {
+		t.Error("message is not marked sent")
+	}
+}
+
+func TestRelay_GivesUpAfterMaxAttempts(t *testing.T) {
+	db, fdb := newFakeDB(t, 1)
+	defer db.Close()
+	box := outbox.New("")
+	pub := &recorder{fail: func(outbox.Message) error { return errors.New("rejected") }}
+	finals := 0
+	relay := outbox.NewRelay(db, box, pub, outbox.RelayConfig{
+		MaxAttempts:    2,
+		InitialBackoff: time.Nanosecond,
+		OnPublishError: func(msg outbox.Message, err error, final bool) {
+			if final {
+				finals++
+			}
+		},
+	})
+	if err := appendMessages(t, db, box, nil, outbox.Message{Topic: "users"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond)
+		if _, err := relay.Poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	row := fdb.row(1)
+	if !row.failed || row.sent || row.attempts != 2 {
+		t.Errorf("have %+v, want failed after 2 attempts", row)
+	}
+	if finals != 1 {
+		t.Errorf("final failure reported %d times, want 1", finals)
+	}
+}
+
+// Несколько Relay не публикуют одно сообщение дважды.
+func TestRelay_ConcurrentRelaysSkipLocked(t *testing.T) {
+	db, fdb := newFakeDB(t, 4)
+	defer db.Close()
+	box := outbox.New("")
+	const total = 40
+	for i := 0; i < total; i++ {
+		if err := appendMessages(t, db, box, nil, outbox.Message{Topic: "users"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pub := &recorder{fail: func(outbox.Message) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			relay := outbox.NewRelay(db, box, pub, outbox.RelayConfig{BatchSize: 5})
+			for {
+				n, err := relay.Poll(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if n == 0 {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := map[int64]bool{}
+	for _, msg := range pub.published() {
+		if seen[msg.ID] {
+			t.Errorf("message %d published twice", msg.ID)
+		}
+		seen[msg.ID] = true
+	}
+	if len(seen) != total {
+		t.Errorf("published %d messages, want %d", len(seen), total)
+	}
+	for id := int64(1); id <= total; id++ {
+		if !fdb.row(id).sent {
+			t.Errorf("message %d is not marked sent", id)
+		}
+	}
+}
+
+// Run публикует и сообщения, добавленные во время работы.
+func TestRelay_RunUntilCancelled(t *testing.T) {
+	db, _ := newFakeDB(t, 1)
+	defer db.Close()
+	box := outbox.New("")
+	pub := &recorder{}
+	relay := outbox.NewRelay(db, box, pub, outbox.RelayConfig{BatchSize: 1, PollInterval: time.Millisecond})
+	if err := appendMessages(t, db, box, nil, outbox.Message{Topic: "a"}, outbox.Message{Topic: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- relay.Run(ctx) }()
+	time.Sleep(20 * time.Millisecond)
+	if err := appendMessages(t, db, box, nil, outbox.Message{Topic: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(pub.published()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("have %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not stop")
+	}
+	msgs := pub.published()
+	if len(msgs) != 3 || msgs[0].Topic != "a" || msgs[1].Topic != "b" || msgs[2].Topic != "c" {
+		t.Errorf("published %+v", msgs)
+	}
+}
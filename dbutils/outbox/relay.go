@@ -0,0 +1,188 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/juju/errors"
+
+	"github.com/r3code/go-useful-snippets/dbutils/transactor"
+)
+
+// Publisher публикует сообщение, например в брокер сообщений.
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+}
+
+// PublisherFunc функция, реализующая Publisher.
+type PublisherFunc func(ctx context.Context, msg Message) error
+
+// Publish вызывает f.
+func (f PublisherFunc) Publish(ctx context.Context, msg Message) error {
+	return f(ctx, msg)
+}
+
+// RelayConfig настройки `Relay`. Нулевые значения заменяются значениями
+// по умолчанию.
+type RelayConfig struct {
+	// BatchSize сколько сообщений выбирать за раз, по умолчанию 100
+	BatchSize int
+	// PollInterval пауза между опросами, когда сообщений нет, по умолчанию 1с
+	PollInterval time.Duration
+	// MaxAttempts число попыток публикации, после которых сообщение
+	// помечается failed_at и больше не публикуется, по умолчанию 10
+	MaxAttempts int
+	// InitialBackoff пауза перед второй попыткой, по умолчанию 1с, дальше
+	// удваивается
+	InitialBackoff time.Duration
+	// MaxBackoff наибольшая пауза, по умолчанию 5 минут
+	MaxBackoff time.Duration
+	// OnPublishError вызывается при каждой ошибке публикации, final -
+	// попытки исчерпаны
+	OnPublishError func(msg Message, err error, final bool)
+	// OnError вызывается при ошибках опроса в Run
+	OnError func(err error)
+}
+
+func (c RelayConfig) withDefaults() RelayConfig {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = time.Second
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 10
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 5 * time.Minute
+	}
+	return c
+}
+
+// backoff пауза после неудачной попытки attempt (с 1).
+func (c RelayConfig) backoff(attempt int) time.Duration {
+	d := c.InitialBackoff
+	for i := 1; i < attempt && d < c.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+	return d
+}
+
+// interval представляет d значением типа interval Postgres.
+func interval(d time.Duration) string {
+	return fmt.Sprintf("%d microseconds", d/time.Microsecond)
+}
+
+// Relay выбирает неотправленные сообщения из outbox и публикует их.
+//
+// Время в запросах берется из БД (now()), а не из часов приложения, чтобы
+// расхождение часов процессов не сдвигало повторные попытки.
+//
+// Сообщения выбираются в транзакции с FOR UPDATE SKIP LOCKED, поэтому
+// несколько Relay, в том числе в разных процессах, не публикуют одно
+// сообщение одновременно. Порядок публикации - по ID, но сообщение,
+// публикация которого не удалась, будет опубликовано позже следующих.
+type Relay struct {
+	db  *sqlx.DB
+	pub Publisher
+	cfg RelayConfig
+
+	selectQuery string
+	sentQuery   string
+	retryQuery  string
+	failedQuery string
+}
+
+// NewRelay создает Relay для сообщений box в db.
+func NewRelay(db *sqlx.DB, box *Outbox, pub Publisher, cfg RelayConfig) *Relay {
+	t := box.table
+	return &Relay{
+		db:  db,
+		pub: pub,
+		cfg: cfg.withDefaults(),
+		selectQuery: db.Rebind(fmt.Sprintf("SELECT id, topic, key, payload, attempts, created_at FROM %s"+
+			" WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= now()"+
+			" ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED", t)),
+		sentQuery:   db.Rebind(fmt.Sprintf("UPDATE %s SET sent_at = now() WHERE id = ?", t)),
+		retryQuery:  db.Rebind(fmt.Sprintf("UPDATE %s SET attempts = ?, last_error = ?, next_attempt_at = now() + CAST(? AS interval) WHERE id = ?", t)),
+		failedQuery: db.Rebind(fmt.Sprintf("UPDATE %s SET attempts = ?, last_error = ?, failed_at = now() WHERE id = ?", t)),
+	}
+}
+
+// Run опрашивает outbox, пока не отменен ctx, и возвращает ctx.Err().
+// Если выбрана полная пачка, следующий опрос выполняется сразу.
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		n, err := r.Poll(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && r.cfg.OnError != nil {
+			r.cfg.OnError(err)
+		}
+		if err == nil && n == r.cfg.BatchSize {
+			continue
+		}
+		timer := time.NewTimer(r.cfg.PollInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Poll выбирает и публикует одну пачку сообщений в транзакции, возвращает
+// число выбранных сообщений. Опубликованные помечаются sent_at, для
+// остальных назначается следующая попытка.
+func (r *Relay) Poll(ctx context.Context) (n int, err error) {
+	err = transactor.WithCtxTransaction(ctx, nil, r.db, func(tx transactor.TransactionX) error {
+		var msgs []Message
+		if err := tx.Select(&msgs, r.selectQuery, r.cfg.BatchSize); err != nil {
+			return errors.Annotate(err, "Outbox select failed")
+		}
+		n = len(msgs)
+		for _, msg := range msgs {
+			if err := r.publish(ctx, tx, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// publish публикует сообщение и записывает результат.
+func (r *Relay) publish(ctx context.Context, tx transactor.TransactionX, msg Message) error {
+	pubErr := r.pub.Publish(ctx, msg)
+	if pubErr == nil {
+		_, err := tx.Exec(r.sentQuery, msg.ID)
+		return errors.Annotatef(err, "Outbox mark message %d sent failed", msg.ID)
+	}
+	if ctx.Err() != nil {
+		// публикацию прервала отмена, это не неудачная попытка
+		return ctx.Err()
+	}
+	attempts := msg.Attempts + 1
+	final := attempts >= r.cfg.MaxAttempts
+	if r.cfg.OnPublishError != nil {
+		r.cfg.OnPublishError(msg, pubErr, final)
+	}
+	var err error
+	if final {
+		_, err = tx.Exec(r.failedQuery, attempts, pubErr.Error(), msg.ID)
+	} else {
+		_, err = tx.Exec(r.retryQuery, attempts, pubErr.Error(), interval(r.cfg.backoff(attempts)), msg.ID)
+	}
+	return errors.Annotatef(err, "Outbox mark message %d failed", msg.ID)
+}
@@ -0,0 +1,84 @@
+package transactor
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// FromContext возвращает транзакцию в db, в которой выполняется ctx (ctx
+// получен из `TransactionX.Context()`), а если ее нет - db. Методы без
+// контекста выполняются с ctx.
+//
+// Так репозиторий, выполняющий запросы через FromContext, пишется один раз
+// и сам участвует в транзакции, открытой вызывающим кодом:
+//
+//	func (r *Repo) Save(ctx context.Context, u User) error {
+//		_, err := transactor.FromContext(ctx, r.db).NamedExec(insertUser, u)
+//		return err
+//	}
+func FromContext(ctx context.Context, db *sqlx.DB) Executor {
+	if state := ambientTx(ctx, db); state != nil {
+		return &ctxTx{Tx: state.tx, ctx: ctx, state: state}
+	}
+	return &ctxDB{DB: db, ctx: ctx}
+}
+
+// ctxDB БД, методы без контекста которой выполняются с контекстом ctx.
+type ctxDB struct {
+	*sqlx.DB
+	ctx context.Context
+}
+
+func (db *ctxDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(db.ctx, query, args...)
+}
+
+func (db *ctxDB) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.PrepareContext(db.ctx, query)
+}
+
+func (db *ctxDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.QueryContext(db.ctx, query, args...)
+}
+
+func (db *ctxDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRowContext(db.ctx, query, args...)
+}
+
+func (db *ctxDB) NamedQuery(query string, arg interface{}) (*sqlx.Rows, error) {
+	return db.DB.NamedQueryContext(db.ctx, query, arg)
+}
+
+func (db *ctxDB) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	return db.DB.NamedExecContext(db.ctx, query, arg)
+}
+
+func (db *ctxDB) Select(dest interface{}, query string, args ...interface{}) error {
+	return db.DB.SelectContext(db.ctx, dest, query, args...)
+}
+
+func (db *ctxDB) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	return db.DB.QueryxContext(db.ctx, query, args...)
+}
+
+func (db *ctxDB) QueryRowx(query string, args ...interface{}) *sqlx.Row {
+	return db.DB.QueryRowxContext(db.ctx, query, args...)
+}
+
+func (db *ctxDB) Get(dest interface{}, query string, args ...interface{}) error {
+	return db.DB.GetContext(db.ctx, dest, query, args...)
+}
+
+func (db *ctxDB) MustExec(query string, args ...interface{}) sql.Result {
+	return db.DB.MustExecContext(db.ctx, query, args...)
+}
+
+func (db *ctxDB) Preparex(query string) (*sqlx.Stmt, error) {
+	return db.DB.PreparexContext(db.ctx, query)
+}
+
+func (db *ctxDB) PrepareNamed(query string) (*sqlx.NamedStmt, error) {
+	return db.DB.PrepareNamedContext(db.ctx, query)
+}
@@ -0,0 +1,88 @@
+package transactor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/r3code/go-useful-snippets/dbutils/internal/testutil"
+	"github.com/r3code/go-useful-snippets/dbutils/transactor"
+)
+
+type userRepo struct {
+	db *sqlx.DB
+}
+
+func (r *userRepo) Save(ctx context.Context, name string) error {
+	_, err := transactor.FromContext(ctx, r.db).Exec("INSERT " + name)
+	return err
+}
+
+func TestFromContext_WithoutTransaction(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	repo := &userRepo{db: db}
+
+	if err := repo.Save(context.Background(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := fdb.Statements(), "INSERT alice"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+func TestFromContext_JoinsTransaction(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	repo := &userRepo{db: db}
+
+	err := transactor.WithCtxTransaction(context.Background(), nil, db, func(tx transactor.TransactionX) error {
+		if err := repo.Save(tx.Context(), "alice"); err != nil {
+			return err
+		}
+		return transactor.WithCtxTransaction(tx.Context(), nil, db, func(tx transactor.TransactionX) error {
+			return repo.Save(tx.Context(), "bob")
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "BEGIN; INSERT alice; SAVEPOINT sp_1; INSERT bob; RELEASE SAVEPOINT sp_1; COMMIT"
+	if have := fdb.Statements(); have != want {
+		t.Errorf("have %q\nwant %q", have, want)
+	}
+}
+
+// Транзакция другой БД не используется.
+func TestFromContext_OtherDB(t *testing.T) {
+	db1, fdb1 := testutil.NewFakeDB(t.Name(), 1)
+	defer db1.Close()
+	db2, fdb2 := testutil.NewFakeDB(t.Name(), 1)
+	defer db2.Close()
+	repo := &userRepo{db: db2}
+
+	err := transactor.WithCtxTransaction(context.Background(), nil, db1, func(tx transactor.TransactionX) error {
+		return repo.Save(tx.Context(), "alice")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := fdb1.Statements(), "BEGIN; COMMIT"; have != want {
+		t.Errorf("db1: have %q, want %q", have, want)
+	}
+	if have, want := fdb2.Statements(), "INSERT alice"; have != want {
+		t.Errorf("db2: have %q, want %q", have, want)
+	}
+}
+
+func TestFromContext_UsesContext(t *testing.T) {
+	db, _ := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := transactor.FromContext(ctx, db).Exec("INSERT alice"); err != context.Canceled {
+		t.Errorf("have %v, want context.Canceled", err)
+	}
+}
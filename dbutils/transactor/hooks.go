@@ -0,0 +1,116 @@
+package transactor
+
+import (
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/juju/errors"
+)
+
+// OnCommit и OnRollback: функции вызываются после завершения транзакции в
+// порядке регистрации. Ошибки и паники функций не прерывают вызов
+// остальных, а собираются в multierror, который возвращается вместе с
+// ошибкой транзакции, если она была. Ошибка OnCommit не отменяет фиксацию.
+//
+// Функции, зарегистрированные во вложенной транзакции, переходят во
+// внешнюю при RELEASE SAVEPOINT. При ROLLBACK TO SAVEPOINT их OnCommit
+// отбрасываются, а OnRollback вызываются сразу.
+func (tx *ctxTx) OnCommit(fn func() error) {
+	tx.state.mu.Lock()
+	tx.state.onCommit = append(tx.state.onCommit, fn)
+	tx.state.mu.Unlock()
+}
+
+func (tx *ctxTx) OnRollback(fn func() error) {
+	tx.state.mu.Lock()
+	tx.state.onRollback = append(tx.state.onRollback, fn)
+	tx.state.mu.Unlock()
+}
+
+// hookMarks возвращает число зарегистрированных функций.
+func (s *txState) hookMarks() (commitMark, rollbackMark int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.onCommit), len(s.onRollback)
+}
+
+// rollbackHooks отбрасывает функции, зарегистрированные после отметок, и
+// возвращает OnRollback из них.
+func (s *txState) rollbackHooks(commitMark, rollbackMark int) []func() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hooks := append([]func() error(nil), s.onRollback[rollbackMark:]...)
+	s.onCommit = s.onCommit[:commitMark]
+	s.onRollback = s.onRollback[:rollbackMark]
+	return hooks
+}
+
+// takeHooks забирает функции OnCommit или OnRollback при завершении
+// транзакции.
+func (s *txState) takeHooks(committed bool) []func() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hooks := s.onRollback
+	if committed {
+		hooks = s.onCommit
+	}
+	s.onCommit, s.onRollback = nil, nil
+	return hooks
+}
+
+// HookError ошибки и паники функций OnCommit или OnRollback. Committed
+// сообщает, что транзакция зафиксирована и повторять ее нельзя. Коды
+// SQLSTATE внутри HookError не учитываются `SQLState` и `IsRetryable`.
+type HookError struct {
+	Committed bool
+	Errors    *multierror.Error
+}
+
+func (e *HookError) Error() string {
+	return e.Errors.Error()
+}
+
+// Unwrap возвращает исходные ошибки функций без аннотаций, чтобы их можно
+// было найти через errors.Is и errors.As.
+func (e *HookError) Unwrap() []error {
+	if e.Errors == nil {
+		return nil
+	}
+	errs := make([]error, len(e.Errors.Errors))
+	for i, err := range e.Errors.Errors {
+		errs[i] = errors.Cause(err)
+	}
+	return errs
+}
+
+// runHooks вызывает все функции и собирает их ошибки в `*HookError`.
+func runHooks(kind string, committed bool, hooks []func() error) error {
+	var result *multierror.Error
+	for i, fn := range hooks {
+		if err := runHook(fn); err != nil {
+			result = multierror.Append(result, errors.Annotatef(err, "%s hook %d failed", kind, i))
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	return &HookError{Committed: committed, Errors: result}
+}
+
+func runHook(fn func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = errors.Errorf("panic: %v", p)
+		}
+	}()
+	return fn()
+}
+
+// appendErr объединяет ошибки, любая из которых может быть nil.
+func appendErr(err, other error) error {
+	if other == nil {
+		return err
+	}
+	if err == nil {
+		return other
+	}
+	return multierror.Append(err, other)
+}
@@ -0,0 +1,145 @@
+package transactor_test
+
+import (
+	"context"
+	stderrors "errors"
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+
+	"github.com/r3code/go-useful-snippets/dbutils/internal/testutil"
+	"github.com/r3code/go-useful-snippets/dbutils/transactor"
+)
+
+// hook записывает свое имя в лог подставной БД.
+func hook(fdb *testutil.FakeDB, name string) func() error {
+	return func() error {
+		fdb.Record(name)
+		return nil
+	}
+}
+
+func TestOnCommit_RunsAfterCommitInOrder(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+
+	err := transactor.WithCtxTransaction(context.Background(), nil, db, func(tx transactor.TransactionX) error {
+		tx.OnCommit(hook(fdb, "commit 1"))
+		tx.OnRollback(hook(fdb, "rollback 1"))
+		tx.MustExec("INSERT 1")
+		tx.OnCommit(hook(fdb, "commit 2"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := fdb.Statements(), "BEGIN; INSERT 1; COMMIT; commit 1; commit 2"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+func TestOnRollback_RunsAfterRollback(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	funcErr := errors.New("func failed")
+
+	err := transactor.WithTransaction(db, func(tx transactor.TransactionX) error {
+		tx.OnCommit(hook(fdb, "commit 1"))
+		tx.OnRollback(hook(fdb, "rollback 1"))
+		tx.OnRollback(hook(fdb, "rollback 2"))
+		return funcErr
+	})
+	if errors.Cause(err) != funcErr {
+		t.Errorf("have %v, want %v", err, funcErr)
+	}
+	if have, want := fdb.Statements(), "BEGIN; ROLLBACK; rollback 1; rollback 2"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+func TestOnRollback_RunsAfterFailedCommit(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	fdb.Commit = func() error { return errors.New("connection lost") }
+
+	err := transactor.WithTransaction(db, func(tx transactor.TransactionX) error {
+		tx.OnCommit(hook(fdb, "commit 1"))
+		tx.OnRollback(hook(fdb, "rollback 1"))
+		return nil
+	})
+	if err == nil {
+		t.Fatal("want the commit error")
+	}
+	if have, want := fdb.Statements(), "BEGIN; COMMIT failed; rollback 1"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+// Ошибки и паники функций собираются, остальные функции вызываются.
+func TestOnCommit_CollectsErrorsAndPanics(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+
+	errCache := errors.New("cache unavailable")
+	err := transactor.WithTransaction(db, func(tx transactor.TransactionX) error {
+		tx.OnCommit(func() error { return errCache })
+		tx.OnCommit(func() error { panic("boom") })
+		tx.OnCommit(hook(fdb, "commit 3"))
+		return nil
+	})
+	if err == nil {
+		t.Fatal("want hook errors")
+	}
+	for _, want := range []string{"OnCommit hook 0 failed: cache unavailable", "OnCommit hook 1 failed: panic: boom"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	var hookErr *transactor.HookError
+	if !stderrors.As(err, &hookErr) || !hookErr.Committed {
+		t.Errorf("want a committed *HookError, have %#v", err)
+	}
+	if !stderrors.Is(err, errCache) {
+		t.Errorf("errors.Is must find the hook error in %v", err)
+	}
+	if have, want := fdb.Statements(), "BEGIN; COMMIT; commit 3"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+// Откат вложенной транзакции вызывает ее OnRollback и отбрасывает OnCommit,
+// функции успешной вложенной транзакции ждут фиксации внешней.
+func TestHooks_Nested(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	nestedErr := errors.New("nested failed")
+
+	err := transactor.WithTransaction(db, func(tx transactor.TransactionX) error {
+		tx.OnCommit(hook(fdb, "outer commit"))
+		err := transactor.WithCtxTransaction(tx.Context(), nil, db, func(tx transactor.TransactionX) error {
+			tx.OnCommit(hook(fdb, "released commit"))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = transactor.WithCtxTransaction(tx.Context(), nil, db, func(tx transactor.TransactionX) error {
+			tx.OnCommit(hook(fdb, "dropped commit"))
+			tx.OnRollback(hook(fdb, "nested rollback"))
+			return nestedErr
+		})
+		if errors.Cause(err) != nestedErr {
+			t.Errorf("have %v, want %v", err, nestedErr)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "BEGIN; SAVEPOINT sp_1; RELEASE SAVEPOINT sp_1; SAVEPOINT sp_2; ROLLBACK TO SAVEPOINT sp_2; " +
+		"RELEASE SAVEPOINT sp_2; nested rollback; COMMIT; outer commit; released commit"
+	if have := fdb.Statements(); have != want {
+		t.Errorf("have %q\nwant %q", have, want)
+	}
+}
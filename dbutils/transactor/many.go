@@ -0,0 +1,111 @@
+package transactor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/jmoiron/sqlx"
+)
+
+// WithEachTransaction запускает функции по очереди, каждую в своей
+// транзакции, и останавливается на первой ошибке. Транзакции уже
+// выполненных функций остаются зафиксированными, для атомарного выполнения
+// используйте `WithAtomicTransaction`.
+func WithEachTransaction(db *sqlx.DB, funcs ...TxFunc) error {
+	for _, fn := range funcs {
+		if err := WithTransaction(db, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StepPolicy задает выполнение шагов в `WithAtomicTransaction`.
+// Нулевое значение - все шаги в одной транзакции, первая ошибка откатывает
+// ее целиком.
+type StepPolicy struct {
+	// Savepoints выполняет каждый шаг в своей точке сохранения, так ошибка
+	// шага откатывает его изменения до того, как решается судьба транзакции
+	Savepoints bool
+	// ContinueOnError продолжает выполнение после ошибки шага: изменения
+	// шага откатываются до точки сохранения (включает Savepoints), остальные
+	// шаги фиксируются
+	ContinueOnError bool
+}
+
+// StepError ошибка шага `WithAtomicTransaction`, Step - индекс функции
+// в списке, начиная с 0.
+type StepError struct {
+	Step int
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %d: %v", e.Step, e.Err)
+}
+
+// Unwrap для errors.Is и errors.As возвращает ошибку шага.
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// StepsError возвращается `WithAtomicTransaction` с ContinueOnError, если
+// часть шагов завершилась ошибкой, и содержит их ошибки.
+type StepsError struct {
+	Failed []*StepError
+}
+
+func (e *StepsError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d steps failed", len(e.Failed))
+	for _, err := range e.Failed {
+		fmt.Fprintf(&b, "; %v", err)
+	}
+	return b.String()
+}
+
+// WithAtomicTransaction выполняет функции по очереди в одной транзакции,
+// открытой как `WithCtxTransaction`: либо фиксируются результаты всех
+// функций, либо ни одной. Внутри внешней транзакции (ctx из
+// `TransactionX.Context()`) шаги выполняются в ней, в точке сохранения.
+//
+// По умолчанию первая ошибка останавливает выполнение и откатывает
+// транзакцию, errors.Cause возвращенной ошибки - `*StepError`.
+//
+// С policy.ContinueOnError ошибка или паника шага откатывает только его
+// изменения, а транзакция с остальными шагами фиксируется. Если ошибки
+// были, возвращается `*StepsError`; если не удалась и фиксация, ее ошибка
+// объединяется со `*StepsError` в multierror.
+func WithAtomicTransaction(ctx context.Context, opt *sql.TxOptions, db *sqlx.DB, policy StepPolicy, funcs ...TxFunc) error {
+	var failed []*StepError
+	err := WithCtxTransaction(ctx, opt, db, func(tx TransactionX) error {
+		for i, fn := range funcs {
+			var err error
+			if policy.Savepoints || policy.ContinueOnError {
+				err = WithCtxTransaction(tx.Context(), nil, db, fn)
+			} else {
+				err = fn(tx)
+			}
+			if err == nil {
+				continue
+			}
+			step := &StepError{Step: i, Err: err}
+			if !policy.ContinueOnError {
+				return step
+			}
+			failed = append(failed, step)
+		}
+		return nil
+	})
+	if len(failed) == 0 {
+		return err
+	}
+	stepsErr := &StepsError{Failed: failed}
+	if err != nil {
+		return multierror.Append(err, stepsErr)
+	}
+	return stepsErr
+}
@@ -0,0 +1,92 @@
+package transactor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/juju/errors"
+
+	"github.com/r3code/go-useful-snippets/dbutils/internal/testutil"
+	"github.com/r3code/go-useful-snippets/dbutils/transactor"
+)
+
+func step(query string, err error) transactor.TxFunc {
+	return func(tx transactor.TransactionX) error {
+		tx.MustExec(query)
+		return err
+	}
+}
+
+func TestWithEachTransaction_CommitsEachStep(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	stepErr := errors.New("step failed")
+
+	err := transactor.WithEachTransaction(db, step("S1", nil), step("S2", stepErr), step("S3", nil))
+	if errors.Cause(err) != stepErr {
+		t.Errorf("have %v, want %v", err, stepErr)
+	}
+	if have, want := fdb.Statements(), "BEGIN; S1; COMMIT; BEGIN; S2; ROLLBACK"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+func TestWithAtomicTransaction_RollsBackAllSteps(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	stepErr := errors.New("step failed")
+
+	err := transactor.WithAtomicTransaction(context.Background(), nil, db, transactor.StepPolicy{},
+		step("S1", nil), step("S2", nil), step("S3", stepErr), step("S4", nil))
+	se, ok := errors.Cause(err).(*transactor.StepError)
+	if !ok {
+		t.Fatalf("have %v, want *StepError", err)
+	}
+	if se.Step != 2 || se.Err != stepErr {
+		t.Errorf("have step %d: %v, want step 2: %v", se.Step, se.Err, stepErr)
+	}
+	if have, want := fdb.Statements(), "BEGIN; S1; S2; S3; ROLLBACK"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+func TestWithAtomicTransaction_Savepoints(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+
+	err := transactor.WithAtomicTransaction(context.Background(), nil, db, transactor.StepPolicy{Savepoints: true},
+		step("S1", nil), step("S2", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "BEGIN; SAVEPOINT sp_1; S1; RELEASE SAVEPOINT sp_1; SAVEPOINT sp_2; S2; RELEASE SAVEPOINT sp_2; COMMIT"
+	if have := fdb.Statements(); have != want {
+		t.Errorf("have %q\nwant %q", have, want)
+	}
+}
+
+func TestWithAtomicTransaction_ContinueOnError(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	stepErr := errors.New("step failed")
+	panicking := func(tx transactor.TransactionX) error { panic("boom") }
+
+	err := transactor.WithAtomicTransaction(context.Background(), nil, db, transactor.StepPolicy{ContinueOnError: true},
+		step("S1", stepErr), panicking, step("S3", nil))
+	se, ok := err.(*transactor.StepsError)
+	if !ok {
+		t.Fatalf("have %v, want *StepsError", err)
+	}
+	if len(se.Failed) != 2 || se.Failed[0].Step != 0 || se.Failed[1].Step != 1 {
+		t.Fatalf("have %v, want steps 0 and 1 failed", err)
+	}
+	if errors.Cause(se.Failed[0].Err) != stepErr {
+		t.Errorf("have %v, want %v", se.Failed[0].Err, stepErr)
+	}
+	want := "BEGIN; SAVEPOINT sp_1; S1; ROLLBACK TO SAVEPOINT sp_1; RELEASE SAVEPOINT sp_1; " +
+		"SAVEPOINT sp_2; ROLLBACK TO SAVEPOINT sp_2; RELEASE SAVEPOINT sp_2; " +
+		"SAVEPOINT sp_3; S3; RELEASE SAVEPOINT sp_3; COMMIT"
+	if have := fdb.Statements(); have != want {
+		t.Errorf("have %q\nwant %q", have, want)
+	}
+}
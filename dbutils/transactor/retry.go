@@ -0,0 +1,189 @@
+package transactor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/jmoiron/sqlx"
+	"github.com/juju/errors"
+	"github.com/lib/pq"
+)
+
+// Коды SQLSTATE, после которых транзакцию имеет смысл повторить.
+const (
+	SQLStateSerializationFailure = "40001"
+	SQLStateDeadlockDetected     = "40P01"
+)
+
+// RetryPolicy задает повтор транзакции в `WithRetryTransaction`.
+// Нулевое значение - 3 попытки с паузой от 10мс до 1с.
+type RetryPolicy struct {
+	// MaxAttempts число попыток, включая первую, по умолчанию 3
+	MaxAttempts int
+	// InitialBackoff пауза перед второй попыткой, по умолчанию 10мс
+	InitialBackoff time.Duration
+	// MaxBackoff наибольшая пауза, по умолчанию 1с
+	MaxBackoff time.Duration
+	// Multiplier во сколько раз растет пауза, по умолчанию 2
+	Multiplier float64
+	// Retryable решает, повторять ли транзакцию после ошибки, по умолчанию
+	// IsRetryable
+	Retryable func(err error) bool
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 10 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = time.Second
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.Retryable == nil {
+		p.Retryable = IsRetryable
+	}
+	return p
+}
+
+// backoff пауза после попытки attempt (с 1), со случайным разбросом
+// от половины до полного значения, чтобы конкурирующие транзакции не
+// повторялись одновременно.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		d *= p.Multiplier
+		if d >= float64(p.MaxBackoff) {
+			d = float64(p.MaxBackoff)
+			break
+		}
+	}
+	return time.Duration(d/2 + rand.Float64()*d/2)
+}
+
+// RetryError возвращается `WithRetryTransaction`, если транзакцию не удалось
+// выполнить за несколько попыток, и содержит ошибки всех попыток.
+type RetryError struct {
+	Attempts []error
+}
+
+func (e *RetryError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "transaction failed after %d attempts", len(e.Attempts))
+	for i, err := range e.Attempts {
+		fmt.Fprintf(&b, "; attempt %d: %v", i+1, err)
+	}
+	return b.String()
+}
+
+// Last ошибка последней попытки.
+func (e *RetryError) Last() error {
+	return e.Attempts[len(e.Attempts)-1]
+}
+
+// Unwrap для errors.Is и errors.As возвращает ошибку последней попытки.
+func (e *RetryError) Unwrap() error {
+	return e.Last()
+}
+
+// WithRetryTransaction выполняет `fn` в транзакции как `WithCtxTransaction`
+// и повторяет всю транзакцию, если она завершилась ошибкой сериализации
+// (40001) или взаимной блокировкой (40P01), что ожидаемо при уровне
+// изоляции Serializable. `fn` может быть вызвана несколько раз, поэтому не
+// должна иметь побочных эффектов вне транзакции.
+//
+// Если первая попытка завершилась ошибкой, которую не нужно повторять, она
+// возвращается как есть; если повторы были, возвращается `*RetryError`.
+// Если транзакция зафиксирована, но OnCommit вернули ошибки, повтора нет и
+// возвращается `*HookError`.
+// Отмена ctx прерывает ожидание перед повтором.
+//
+// Внутри внешней транзакции (ctx из `TransactionX.Context()`) повторов нет:
+// сбой сериализации прерывает всю внешнюю транзакцию, повторять ее должен
+// тот, кто ее открыл.
+func WithRetryTransaction(ctx context.Context, opt *sql.TxOptions, db *sqlx.DB, policy RetryPolicy, fn TxFunc) error {
+	if ambientTx(ctx, db) != nil {
+		return WithCtxTransaction(ctx, opt, db, fn)
+	}
+	policy = policy.withDefaults()
+	var attempts []error
+	for attempt := 1; ; attempt++ {
+		err := WithCtxTransaction(ctx, opt, db, fn)
+		if err == nil {
+			return nil
+		}
+		if hookErr, ok := err.(*HookError); ok && hookErr.Committed {
+			// транзакция зафиксирована, ошибки только у OnCommit
+			return err
+		}
+		attempts = append(attempts, err)
+		if !policy.Retryable(err) || attempt >= policy.MaxAttempts {
+			if len(attempts) == 1 {
+				return err
+			}
+			return &RetryError{Attempts: attempts}
+		}
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			attempts = append(attempts, ctx.Err())
+			return &RetryError{Attempts: attempts}
+		}
+	}
+}
+
+// IsRetryable сообщает, что ошибка - сбой сериализации или взаимная
+// блокировка, после которых транзакцию можно повторить.
+func IsRetryable(err error) bool {
+	switch SQLState(err) {
+	case SQLStateSerializationFailure, SQLStateDeadlockDetected:
+		return true
+	}
+	return false
+}
+
+// SQLState возвращает код SQLSTATE ошибки базы данных или пустую строку.
+// Ищет *pq.Error или ошибку с методом SQLState() (например, pgconn.PgError)
+// среди причин err, в том числе внутри multierror, кроме ошибок
+// `*HookError`.
+func SQLState(err error) string {
+	for err != nil {
+		switch e := err.(type) {
+		case *HookError:
+			// ошибки OnCommit и OnRollback не относятся к транзакции
+			return ""
+		case *pq.Error:
+			return string(e.Code)
+		case interface{ SQLState() string }:
+			return e.SQLState()
+		case *multierror.Error:
+			for _, inner := range e.Errors {
+				if code := SQLState(inner); code != "" {
+					return code
+				}
+			}
+			return ""
+		}
+		next := errors.Cause(err)
+		if next == err {
+			u, ok := err.(interface{ Unwrap() error })
+			if !ok {
+				return ""
+			}
+			next = u.Unwrap()
+		}
+		err = next
+	}
+	return ""
+}
@@ -0,0 +1,201 @@
+package transactor_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/lib/pq"
+
+	"github.com/r3code/go-useful-snippets/dbutils/internal/testutil"
+	"github.com/r3code/go-useful-snippets/dbutils/transactor"
+)
+
+var fastRetry = transactor.RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     time.Millisecond,
+}
+
+// failFirst заставляет первые n выполнений выражения вернуть ошибку с кодом.
+func failFirst(fdb *testutil.FakeDB, n int, code pq.ErrorCode) {
+	calls := 0
+	fdb.Exec = func(ctx context.Context, _ *testutil.FakeTx, query string, _ []driver.NamedValue) error {
+		calls++
+		if calls <= n {
+			return &pq.Error{Code: code, Message: "could not serialize access"}
+		}
+		return nil
+	}
+}
+
+func TestWithRetryTransaction_RetriesSerializationFailure(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	failFirst(fdb, 2, "40001")
+
+	calls := 0
+	opt := &sql.TxOptions{Isolation: sql.LevelSerializable}
+	err := transactor.WithRetryTransaction(context.Background(), opt, db, fastRetry, func(tx transactor.TransactionX) error {
+		calls++
+		_, err := tx.Exec("UPDATE accounts")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("TxFunc called %d times, want 3", calls)
+	}
+	want := "BEGIN; UPDATE accounts; ROLLBACK; BEGIN; UPDATE accounts; ROLLBACK; BEGIN; UPDATE accounts; COMMIT"
+	if have := fdb.Statements(); have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+func TestWithRetryTransaction_ReportsAllAttempts(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	failFirst(fdb, 10, "40P01")
+
+	err := transactor.WithRetryTransaction(context.Background(), nil, db, fastRetry, func(tx transactor.TransactionX) error {
+		_, err := tx.Exec("UPDATE accounts")
+		return err
+	})
+	retryErr, ok := err.(*transactor.RetryError)
+	if !ok {
+		t.Fatalf("have %T %v, want *RetryError", err, err)
+	}
+	if len(retryErr.Attempts) != 3 {
+		t.Errorf("have %d attempts, want 3", len(retryErr.Attempts))
+	}
+	if code := transactor.SQLState(retryErr.Last()); code != "40P01" {
+		t.Errorf("SQLState of the last attempt = %q, want 40P01", code)
+	}
+	if !strings.Contains(err.Error(), "attempt 3:") {
+		t.Errorf("error %q does not report every attempt", err)
+	}
+}
+
+func TestWithRetryTransaction_NoRetryForOtherErrors(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	failFirst(fdb, 10, "23505") // unique_violation
+
+	calls := 0
+	err := transactor.WithRetryTransaction(context.Background(), nil, db, fastRetry, func(tx transactor.TransactionX) error {
+		calls++
+		_, err := tx.Exec("INSERT")
+		return err
+	})
+	if calls != 1 {
+		t.Errorf("TxFunc called %d times, want 1", calls)
+	}
+	if _, ok := errors.Cause(err).(*pq.Error); !ok {
+		t.Errorf("have %v, want the original *pq.Error", err)
+	}
+}
+
+func TestWithRetryTransaction_ContextCancelStopsRetries(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	failFirst(fdb, 10, "40001")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	slow := transactor.RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Minute}
+	start := time.Now()
+	err := transactor.WithRetryTransaction(ctx, nil, db, slow, func(tx transactor.TransactionX) error {
+		_, err := tx.Exec("UPDATE")
+		return err
+	})
+	if time.Since(start) > time.Second {
+		t.Error("the backoff was not interrupted by the context")
+	}
+	retryErr, ok := err.(*transactor.RetryError)
+	if !ok || retryErr.Last() != context.DeadlineExceeded {
+		t.Errorf("have %v, want *RetryError ending with the context error", err)
+	}
+}
+
+// Внутри внешней транзакции повторять нечего: сбой сериализации прерывает
+// ее целиком.
+func TestWithRetryTransaction_NestedDoesNotRetry(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+
+	calls := 0
+	err := transactor.WithTransaction(db, func(tx transactor.TransactionX) error {
+		fdb.Exec = func(ctx context.Context, _ *testutil.FakeTx, query string, _ []driver.NamedValue) error {
+			if strings.HasPrefix(query, "UPDATE") {
+				return &pq.Error{Code: "40001", Message: "could not serialize access"}
+			}
+			return nil
+		}
+		return transactor.WithRetryTransaction(tx.Context(), nil, db, fastRetry, func(tx transactor.TransactionX) error {
+			calls++
+			_, err := tx.Exec("UPDATE accounts")
+			return err
+		})
+	})
+	if !transactor.IsRetryable(err) {
+		t.Errorf("have %v, want a serialization failure", err)
+	}
+	if calls != 1 {
+		t.Errorf("TxFunc called %d times, want 1", calls)
+	}
+	want := "BEGIN; SAVEPOINT sp_1; UPDATE accounts; ROLLBACK TO SAVEPOINT sp_1; RELEASE SAVEPOINT sp_1; ROLLBACK"
+	if have := fdb.Statements(); have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+// Ошибка OnCommit приходит после фиксации: повтор выполнил бы TxFunc еще
+// раз, даже если в ошибке код сбоя сериализации.
+func TestWithRetryTransaction_NoRetryAfterCommit(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+
+	err := transactor.WithRetryTransaction(context.Background(), nil, db, fastRetry, func(tx transactor.TransactionX) error {
+		tx.MustExec("INSERT 1")
+		tx.OnCommit(func() error {
+			return &pq.Error{Code: "40001", Message: "could not serialize access"}
+		})
+		return nil
+	})
+	hookErr, ok := err.(*transactor.HookError)
+	if !ok || !hookErr.Committed {
+		t.Fatalf("have %#v, want a committed *HookError", err)
+	}
+	if transactor.IsRetryable(err) {
+		t.Error("hook errors must not be retryable")
+	}
+	if have, want := fdb.Statements(), "BEGIN; INSERT 1; COMMIT"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+// Ошибки OnRollback не влияют на решение о повторе.
+func TestWithRetryTransaction_IgnoresRollbackHookErrors(t *testing.T) {
+	db, _ := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+
+	calls := 0
+	err := transactor.WithRetryTransaction(context.Background(), nil, db, fastRetry, func(tx transactor.TransactionX) error {
+		calls++
+		tx.OnRollback(func() error {
+			return &pq.Error{Code: "40P01", Message: "deadlock detected"}
+		})
+		return errors.New("validation failed")
+	})
+	if err == nil {
+		t.Fatal("want an error")
+	}
+	if calls != 1 {
+		t.Errorf("TxFunc called %d times, want 1", calls)
+	}
+}
@@ -1,116 +1,314 @@
-package transactor
-
-import (
-	"context"
-	"database/sql"
-
-	"github.com/jmoiron/sqlx"
-	"github.com/juju/errors"
-
-	multierror "github.com/hashicorp/go-multierror"
-)
-
-// Transaction интерфейс моделирующий интерфейс стандартной транзакции в
-// `database/sql`.
-//
-// Позволяет скрыть от функции `TxFunc` методы commit и rollback (они
-// вызываются функцией `WithTransaction`,
-// чтобы они не были вызваны случайно, потому эти методы сюда не включены.
-type Transaction interface {
-	Exec(query string, args ...interface{}) (sql.Result, error)
-	Prepare(query string) (*sql.Stmt, error)
-	Query(query string, args ...interface{}) (*sql.Rows, error)
-	QueryRow(query string, args ...interface{}) *sql.Row
-	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
-}
-
-// TransactionX интерфейс моделирующий методы транзакции sqlx,
-// но без Commit и Rollback, чтобы внутри `TxFunc` нельзя было вызвать их,
-// т.к. работой транзакии управляет функция `WithTransaction`
-type TransactionX interface {
-	Transaction
-	Rebind(query string) string
-	BindNamed(query string, arg interface{}) (string, []interface{}, error)
-	NamedQuery(query string, arg interface{}) (*sqlx.Rows, error)
-	NamedExec(query string, arg interface{}) (sql.Result, error)
-	Select(dest interface{}, query string, args ...interface{}) error
-	Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
-	QueryRowx(query string, args ...interface{}) *sqlx.Row
-	Get(dest interface{}, query string, args ...interface{}) error
-	MustExec(query string, args ...interface{}) sql.Result
-	Preparex(query string) (*sqlx.Stmt, error)
-	Stmtx(stmt interface{}) *sqlx.Stmt
-	NamedStmt(stmt *sqlx.NamedStmt) *sqlx.NamedStmt
-	PrepareNamed(query string) (*sqlx.NamedStmt, error)
-}
-
-// TxFunc будет вызвано с инициализированным объектом `Transaction`
-// которые может быть использоват для исполнения выражений и запросов к БД.
-type TxFunc func(tx TransactionX) error
-
-// WithTransaction создает новую транзакцию, выполняет функцию `TxFunc`,
-// если она выполнена с ошибками, то выполняется откат транзакции (rollback),
-// иначе фиксируется транзакция (commit)
-func WithTransaction(db *sqlx.DB, txFunc TxFunc) (err error) {
-	tx, err := db.Beginx()
-	if err != nil {
-		return errors.Annotate(err, "Transaction Begin failed")
-	}
-	err = execute(tx, txFunc)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func execute(tx *sqlx.Tx, txFunc TxFunc) (err error) {
-	var handleErrors = func() {
-		if p := recover(); p != nil { // err = nil
-			// где-то случилась паника
-			err = errors.Errorf("panic in WithTransaction(): %v", p)
-			rollErr := tx.Rollback()
-			if rollErr != nil {
-				err = multierror.Append(err, errors.Annotate(rollErr, "Failed Transaction Rollback after panic"))
-			}
-			return // err заполнено
-		}
-
-		if err != nil {
-			err = errors.Annotate(err, "Wrapped function exit with error")
-			rollErr := tx.Rollback()
-			if rollErr != nil {
-				err = multierror.Append(err, errors.Annotate(rollErr, "Failed Transaction Rollback after error in wrapped function"))
-			}
-			return // err заполнено
-		}
-		// Если все в проядке, то зафиксируем
-		err = tx.Commit()
-		if err != nil {
-			err = errors.Annotate(err, "Failed Transaction Commit")
-		}
-	}
-
-	defer handleErrors()
-	err = txFunc(tx)
-	return err
-}
-
-// WithTransactionMany запустить несколько функций в транзакции
-func WithTransactionMany(db *sqlx.DB, funcs ...TxFunc) error {
-	for _, fn := range funcs {
-		if err := WithTransaction(db, fn); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-// WithCtxTransaction creates a new transaction with ctx and handles rollback/commit based on the
-// error object returned by the `txFunc`
-func WithCtxTransaction(ctx context.Context, opt *sql.TxOptions, db *sqlx.DB, fn TxFunc) (err error) {
-	tx, err := db.BeginTxx(ctx, opt)
-	if err != nil {
-		return errors.Annotate(err, "Transaction BeginTx failed")
-	}
-	return execute(tx, fn)
-}
+package transactor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/juju/errors"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// Transaction интерфейс моделирующий интерфейс стандартной транзакции в
+// `database/sql`.
+//
+// Позволяет скрыть от функции `TxFunc` методы commit и rollback (они
+// вызываются функцией `WithTransaction`,
+// чтобы они не были вызваны случайно, потому эти методы сюда не включены.
+//
+// Методы без контекста выполняются с контекстом, переданным в
+// `WithCtxTransaction`, поэтому его отмена или таймаут прерывают и их.
+type Transaction interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Executor методы выполнения запросов, общие для транзакции и БД sqlx,
+// см. `FromContext`.
+type Executor interface {
+	Transaction
+	Rebind(query string) string
+	BindNamed(query string, arg interface{}) (string, []interface{}, error)
+	NamedQuery(query string, arg interface{}) (*sqlx.Rows, error)
+	NamedExec(query string, arg interface{}) (sql.Result, error)
+	Select(dest interface{}, query string, args ...interface{}) error
+	Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
+	QueryRowx(query string, args ...interface{}) *sqlx.Row
+	Get(dest interface{}, query string, args ...interface{}) error
+	MustExec(query string, args ...interface{}) sql.Result
+	Preparex(query string) (*sqlx.Stmt, error)
+	PrepareNamed(query string) (*sqlx.NamedStmt, error)
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
+	QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	MustExecContext(ctx context.Context, query string, args ...interface{}) sql.Result
+	PreparexContext(ctx context.Context, query string) (*sqlx.Stmt, error)
+	PrepareNamedContext(ctx context.Context, query string) (*sqlx.NamedStmt, error)
+}
+
+// TransactionX интерфейс моделирующий методы транзакции sqlx,
+// но без Commit и Rollback, чтобы внутри `TxFunc` нельзя было вызвать их,
+// т.к. работой транзакии управляет функция `WithTransaction`
+type TransactionX interface {
+	Executor
+	Stmtx(stmt interface{}) *sqlx.Stmt
+	NamedStmt(stmt *sqlx.NamedStmt) *sqlx.NamedStmt
+	StmtxContext(ctx context.Context, stmt interface{}) *sqlx.Stmt
+	NamedStmtContext(ctx context.Context, stmt *sqlx.NamedStmt) *sqlx.NamedStmt
+	// Context возвращает контекст транзакции. Вызов `WithCtxTransaction` с
+	// этим контекстом и той же БД не открывает новую транзакцию, а
+	// выполняется во вложенной через SAVEPOINT.
+	Context() context.Context
+	// OnCommit регистрирует функцию, которая будет вызвана после успешной
+	// фиксации транзакции, например для публикации событий или сброса кэша.
+	OnCommit(fn func() error)
+	// OnRollback регистрирует функцию, которая будет вызвана после отката
+	// транзакции или неудачной фиксации.
+	OnRollback(fn func() error)
+}
+
+// TxFunc будет вызвано с инициализированным объектом `Transaction`
+// которые может быть использоват для исполнения выражений и запросов к БД.
+type TxFunc func(tx TransactionX) error
+
+// WithTransaction создает новую транзакцию, выполняет функцию `TxFunc`,
+// если она выполнена с ошибками, то выполняется откат транзакции (rollback),
+// иначе фиксируется транзакция (commit)
+//
+// WithTransaction не получает контекст и поэтому не видит внешнюю
+// транзакцию: вызванная внутри `TxFunc` другой транзакции, она открывает
+// независимую транзакцию на другом соединении пула, ее изменения
+// фиксируются, даже если внешняя транзакция затем откатывается (а при пуле
+// из одного соединения вызов заблокируется). Для вложенной транзакции через
+// SAVEPOINT используйте `WithCtxTransaction` с `TransactionX.Context()`.
+func WithTransaction(db *sqlx.DB, txFunc TxFunc) (err error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return errors.Annotate(err, "Transaction Begin failed")
+	}
+	err = execute(context.Background(), db, tx, txFunc)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func execute(ctx context.Context, db *sqlx.DB, tx *sqlx.Tx, txFunc TxFunc) (err error) {
+	state := &txState{db: db, tx: tx}
+	var handleErrors = func() {
+		if p := recover(); p != nil { // err = nil
+			// где-то случилась паника
+			err = errors.Errorf("panic in WithTransaction(): %v", p)
+			rollErr := tx.Rollback()
+			if rollErr != nil {
+				err = multierror.Append(err, errors.Annotate(rollErr, "Failed Transaction Rollback after panic"))
+			}
+			err = appendErr(err, runHooks("OnRollback", false, state.takeHooks(false)))
+			return // err заполнено
+		}
+
+		if err != nil {
+			err = errors.Annotate(err, "Wrapped function exit with error")
+			rollErr := tx.Rollback()
+			if rollErr != nil {
+				err = multierror.Append(err, errors.Annotate(rollErr, "Failed Transaction Rollback after error in wrapped function"))
+			}
+			err = appendErr(err, runHooks("OnRollback", false, state.takeHooks(false)))
+			return // err заполнено
+		}
+		// Если все в проядке, то зафиксируем
+		err = tx.Commit()
+		if err != nil {
+			err = errors.Annotate(err, "Failed Transaction Commit")
+			err = appendErr(err, runHooks("OnRollback", false, state.takeHooks(false)))
+			return
+		}
+		err = runHooks("OnCommit", true, state.takeHooks(true))
+	}
+
+	defer handleErrors()
+	err = txFunc(&ctxTx{Tx: tx, ctx: context.WithValue(ctx, txKey{}, state), state: state})
+	return err
+}
+
+// WithTransactionMany запускает функции по очереди, каждую в своей
+// транзакции, как `WithEachTransaction`.
+//
+// Deprecated: несмотря на название, функции не выполняются в одной
+// транзакции. Используйте `WithEachTransaction` для независимых транзакций
+// или `WithAtomicTransaction` для одной общей.
+func WithTransactionMany(db *sqlx.DB, funcs ...TxFunc) error {
+	return WithEachTransaction(db, funcs...)
+}
+
+// WithCtxTransaction creates a new transaction with ctx and handles rollback/commit based on the
+// error object returned by the `txFunc`
+//
+// Если ctx получен из `TransactionX.Context()` транзакции той же БД, новая
+// транзакция не открывается: `fn` выполняется во внешней транзакции между
+// SAVEPOINT и RELEASE SAVEPOINT, а при ошибке или панике изменения `fn`
+// откатываются через ROLLBACK TO SAVEPOINT, не затрагивая внешнюю
+// транзакцию. opt в этом случае игнорируется. Так функции сервисов можно
+// составлять, не зная, выполняются ли они внутри большей транзакции.
+func WithCtxTransaction(ctx context.Context, opt *sql.TxOptions, db *sqlx.DB, fn TxFunc) (err error) {
+	if state := ambientTx(ctx, db); state != nil {
+		return executeNested(ctx, state, fn)
+	}
+	tx, err := db.BeginTxx(ctx, opt)
+	if err != nil {
+		return errors.Annotate(err, "Transaction BeginTx failed")
+	}
+	return execute(ctx, db, tx, fn)
+}
+
+// txKey ключ, под которым в контексте хранится текущая транзакция.
+type txKey struct{}
+
+// txState транзакция, доступная вложенным вызовам через контекст.
+type txState struct {
+	db         *sqlx.DB
+	tx         *sqlx.Tx
+	savepoints int64 // счетчик для имен точек сохранения
+
+	mu         sync.Mutex
+	onCommit   []func() error
+	onRollback []func() error
+}
+
+// ambientTx возвращает транзакцию из ctx, если она открыта в db.
+func ambientTx(ctx context.Context, db *sqlx.DB) *txState {
+	state, ok := ctx.Value(txKey{}).(*txState)
+	if !ok || state.db != db {
+		return nil
+	}
+	return state
+}
+
+// executeNested выполняет txFunc во внешней транзакции внутри точки
+// сохранения.
+func executeNested(ctx context.Context, state *txState, txFunc TxFunc) (err error) {
+	name := fmt.Sprintf("sp_%d", atomic.AddInt64(&state.savepoints, 1))
+	if _, err = state.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return errors.Annotate(err, "Savepoint failed")
+	}
+	// функции, зарегистрированные после этой отметки, относятся к точке
+	// сохранения
+	commitMark, rollbackMark := state.hookMarks()
+	var rollbackTo = func() error {
+		if _, err := state.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); err != nil {
+			return err
+		}
+		_, err := state.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+		return err
+	}
+	var handleErrors = func() {
+		if p := recover(); p != nil {
+			err = errors.Errorf("panic in nested WithCtxTransaction(): %v", p)
+			if rollErr := rollbackTo(); rollErr != nil {
+				err = multierror.Append(err, errors.Annotate(rollErr, "Failed Rollback to Savepoint after panic"))
+			}
+			err = appendErr(err, runHooks("OnRollback", false, state.rollbackHooks(commitMark, rollbackMark)))
+			return
+		}
+
+		if err != nil {
+			err = errors.Annotate(err, "Wrapped function exit with error")
+			if rollErr := rollbackTo(); rollErr != nil {
+				err = multierror.Append(err, errors.Annotate(rollErr, "Failed Rollback to Savepoint after error in wrapped function"))
+			}
+			err = appendErr(err, runHooks("OnRollback", false, state.rollbackHooks(commitMark, rollbackMark)))
+			return
+		}
+		if _, err = state.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+			err = errors.Annotate(err, "Failed Release Savepoint")
+		}
+	}
+
+	defer handleErrors()
+	err = txFunc(&ctxTx{Tx: state.tx, ctx: ctx, state: state})
+	return err
+}
+
+// ctxTx транзакция, методы без контекста которой выполняются с контекстом
+// транзакции ctx.
+type ctxTx struct {
+	*sqlx.Tx
+	ctx   context.Context
+	state *txState
+}
+
+func (tx *ctxTx) Context() context.Context {
+	return tx.ctx
+}
+
+func (tx *ctxTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(tx.ctx, query, args...)
+}
+
+func (tx *ctxTx) Prepare(query string) (*sql.Stmt, error) {
+	return tx.Tx.PrepareContext(tx.ctx, query)
+}
+
+func (tx *ctxTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(tx.ctx, query, args...)
+}
+
+func (tx *ctxTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRowContext(tx.ctx, query, args...)
+}
+
+func (tx *ctxTx) NamedQuery(query string, arg interface{}) (*sqlx.Rows, error) {
+	return sqlx.NamedQueryContext(tx.ctx, tx.Tx, query, arg)
+}
+
+func (tx *ctxTx) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	return tx.Tx.NamedExecContext(tx.ctx, query, arg)
+}
+
+func (tx *ctxTx) Select(dest interface{}, query string, args ...interface{}) error {
+	return tx.Tx.SelectContext(tx.ctx, dest, query, args...)
+}
+
+func (tx *ctxTx) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	return tx.Tx.QueryxContext(tx.ctx, query, args...)
+}
+
+func (tx *ctxTx) QueryRowx(query string, args ...interface{}) *sqlx.Row {
+	return tx.Tx.QueryRowxContext(tx.ctx, query, args...)
+}
+
+func (tx *ctxTx) Get(dest interface{}, query string, args ...interface{}) error {
+	return tx.Tx.GetContext(tx.ctx, dest, query, args...)
+}
+
+func (tx *ctxTx) MustExec(query string, args ...interface{}) sql.Result {
+	return tx.Tx.MustExecContext(tx.ctx, query, args...)
+}
+
+func (tx *ctxTx) Preparex(query string) (*sqlx.Stmt, error) {
+	return tx.Tx.PreparexContext(tx.ctx, query)
+}
+
+func (tx *ctxTx) Stmtx(stmt interface{}) *sqlx.Stmt {
+	return tx.Tx.StmtxContext(tx.ctx, stmt)
+}
+
+func (tx *ctxTx) NamedStmt(stmt *sqlx.NamedStmt) *sqlx.NamedStmt {
+	return tx.Tx.NamedStmtContext(tx.ctx, stmt)
+}
+
+func (tx *ctxTx) PrepareNamed(query string) (*sqlx.NamedStmt, error) {
+	return tx.Tx.PrepareNamedContext(tx.ctx, query)
+}
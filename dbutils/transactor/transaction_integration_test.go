@@ -150,7 +150,11 @@ func shutdown() {
 }
 
 func TestMain(m *testing.M) {
-	setup()
+	flag.Parse()
+	// с -short выполняются только модульные тесты, база данных не нужна
+	if !testing.Short() {
+		setup()
+	}
 	code := m.Run()
 	shutdown()
 	os.Exit(code)
@@ -0,0 +1,199 @@
+package transactor_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/r3code/go-useful-snippets/dbutils/internal/testutil"
+	"github.com/r3code/go-useful-snippets/dbutils/transactor"
+)
+
+func TestWithCtxTransaction_CommitAndRollback(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	ctx := context.Background()
+
+	err := transactor.WithCtxTransaction(ctx, nil, db, func(tx transactor.TransactionX) error {
+		_, err := tx.ExecContext(ctx, "INSERT 1")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	funcErr := errors.New("func failed")
+	err = transactor.WithCtxTransaction(ctx, nil, db, func(tx transactor.TransactionX) error {
+		tx.MustExec("INSERT 2")
+		return funcErr
+	})
+	if errors.Cause(err) != funcErr {
+		t.Errorf("have %v, want %v", err, funcErr)
+	}
+	if have, want := fdb.Statements(), "BEGIN; INSERT 1; COMMIT; BEGIN; INSERT 2; ROLLBACK"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+// Методы без контекста должны получать контекст транзакции, иначе таймаут
+// не прервет запрос.
+func TestWithCtxTransaction_TimeoutAbortsQueries(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	fdb.Exec = func(ctx context.Context, _ *testutil.FakeTx, query string, _ []driver.NamedValue) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return errors.New("query was not cancelled")
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var execErr error
+	start := time.Now()
+	err := transactor.WithCtxTransaction(ctx, nil, db, func(tx transactor.TransactionX) error {
+		_, execErr = tx.Exec("SELECT pg_sleep(10)")
+		return execErr
+	})
+	if err == nil {
+		t.Fatal("want an error after the timeout")
+	}
+	if execErr != context.DeadlineExceeded {
+		t.Errorf("Exec returned %v, want context.DeadlineExceeded", execErr)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("query ran %v after the timeout", d)
+	}
+}
+
+func TestWithCtxTransaction_GetContext(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	fdb.Query = func(_ context.Context, _ *testutil.FakeTx, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return []string{"count"}, [][]driver.Value{{int64(42)}}, nil
+	}
+	var count int
+	err := transactor.WithCtxTransaction(context.Background(), nil, db, func(tx transactor.TransactionX) error {
+		return tx.GetContext(context.Background(), &count, "SELECT count(*) FROM wtt")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 42 {
+		t.Errorf("have %d, want 42", count)
+	}
+}
+
+// Вложенный вызов с контекстом транзакции выполняется в точке сохранения,
+// ошибка вложенной функции откатывает только ее изменения.
+func TestWithCtxTransaction_NestedSavepoints(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+	nestedErr := errors.New("nested failed")
+
+	err := transactor.WithCtxTransaction(context.Background(), nil, db, func(tx transactor.TransactionX) error {
+		tx.MustExec("INSERT 1")
+		err := transactor.WithCtxTransaction(tx.Context(), nil, db, func(tx transactor.TransactionX) error {
+			tx.MustExec("INSERT 2")
+			return transactor.WithCtxTransaction(tx.Context(), nil, db, func(tx transactor.TransactionX) error {
+				tx.MustExec("INSERT 3")
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		err = transactor.WithCtxTransaction(tx.Context(), nil, db, func(tx transactor.TransactionX) error {
+			tx.MustExec("INSERT 4")
+			return nestedErr
+		})
+		if errors.Cause(err) != nestedErr {
+			t.Errorf("have %v, want %v", err, nestedErr)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "BEGIN; INSERT 1; SAVEPOINT sp_1; INSERT 2; SAVEPOINT sp_2; INSERT 3; RELEASE SAVEPOINT sp_2; " +
+		"RELEASE SAVEPOINT sp_1; SAVEPOINT sp_3; INSERT 4; ROLLBACK TO SAVEPOINT sp_3; RELEASE SAVEPOINT sp_3; COMMIT"
+	if have := fdb.Statements(); have != want {
+		t.Errorf("have %q\nwant %q", have, want)
+	}
+}
+
+func TestWithCtxTransaction_NestedPanicAndError(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 1)
+	defer db.Close()
+
+	err := transactor.WithTransaction(db, func(tx transactor.TransactionX) error {
+		err := transactor.WithCtxTransaction(tx.Context(), nil, db, func(tx transactor.TransactionX) error {
+			panic("boom")
+		})
+		if err == nil {
+			t.Error("want an error after the panic")
+		}
+		// ошибка, которую не обработали, откатывает и внешнюю транзакцию
+		return err
+	})
+	if err == nil {
+		t.Fatal("want an error")
+	}
+	if have, want := fdb.Statements(), "BEGIN; SAVEPOINT sp_1; ROLLBACK TO SAVEPOINT sp_1; RELEASE SAVEPOINT sp_1; ROLLBACK"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+// WithTransaction внутри транзакции открывает независимую транзакцию, ее
+// изменения фиксируются и при откате внешней.
+func TestWithTransaction_NestedIsIndependent(t *testing.T) {
+	db, fdb := testutil.NewFakeDB(t.Name(), 2)
+	defer db.Close()
+	outerErr := errors.New("outer failed")
+
+	err := transactor.WithCtxTransaction(context.Background(), nil, db, func(tx transactor.TransactionX) error {
+		tx.MustExec("INSERT 1")
+		err := transactor.WithTransaction(db, func(tx transactor.TransactionX) error {
+			tx.MustExec("INSERT 2")
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return outerErr
+	})
+	if errors.Cause(err) != outerErr {
+		t.Fatalf("have %v, want %v", err, outerErr)
+	}
+	if have, want := fdb.Statements(), "BEGIN; INSERT 1; BEGIN; INSERT 2; COMMIT; ROLLBACK"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+// Контекст транзакции другой БД не делает вызов вложенным.
+func TestWithCtxTransaction_OtherDBIsIndependent(t *testing.T) {
+	db1, fdb1 := testutil.NewFakeDB(t.Name(), 1)
+	defer db1.Close()
+	db2, fdb2 := testutil.NewFakeDB(t.Name(), 1)
+	defer db2.Close()
+
+	err := transactor.WithCtxTransaction(context.Background(), nil, db1, func(tx transactor.TransactionX) error {
+		return transactor.WithCtxTransaction(tx.Context(), nil, db2, func(tx transactor.TransactionX) error {
+			tx.MustExec("INSERT 1")
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := fdb1.Statements(), "BEGIN; COMMIT"; have != want {
+		t.Errorf("db1: have %q, want %q", have, want)
+	}
+	if have, want := fdb2.Statements(), "BEGIN; INSERT 1; COMMIT"; have != want {
+		t.Errorf("db2: have %q, want %q", have, want)
+	}
+}
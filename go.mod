@@ -0,0 +1,16 @@
+module github.com/r3code/go-useful-snippets
+
+go 1.21
+
+require (
+	github.com/go-kit/kit v0.9.0
+	github.com/juju/errors v0.0.0-20190930114154-d42613fe1ab9
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
+)
+
+require (
+	github.com/go-logfmt/logfmt v0.6.1 // indirect
+	github.com/go-stack/stack v1.8.1 // indirect
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+)
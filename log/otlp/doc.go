@@ -0,0 +1,22 @@
+// Package otlp отправляет записи логгера `log.Logger` в OpenTelemetry Collector по протоколу OTLP/HTTP (JSON).
+//
+// Sink реализует интерфейс `log.Logger`: пары ключ/значение превращаются в OTLP log record,
+// ключ "level" задает уровень серьезности, "msg" тело записи, "time" время события,
+// "trace_id"/"span_id" идентификаторы трассировки, остальные пары становятся атрибутами.
+// Идентификаторы трассировки из контекста добавляет функция `WithContext`.
+//
+// Пример использования:
+//
+//	sink := otlp.NewSink(otlp.Config{
+//		Endpoint: "http://localhost:4318/v1/logs",
+//		Resource: []interface{}{"service.name", "billing"},
+//		OnError:  func(err error) { fmt.Fprintln(os.Stderr, "otlp export:", err) },
+//	})
+//	defer sink.Close(context.Background())
+//	logger := log.With(sink, "time", log.DefaultTimestampUTC)
+//	otlp.WithContext(logger, r.Context()).Log("level", "info", "msg", "payment accepted", "amount", 42)
+//
+// Ошибки отправки не возвращаются из `Log`: приемник может быть недоступен долго, а
+// логирование не должно от этого отказывать. Их получает `Config.OnError`, последнюю
+// можно узнать через `Sink.LastError`.
+package otlp
@@ -0,0 +1,186 @@
+package otlp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Типы ниже повторяют JSON-представление OTLP (opentelemetry/proto/collector/logs/v1).
+// Экспортируются, чтобы тестовый приемник (fake collector) мог разобрать запрос.
+
+// ExportLogsServiceRequest тело запроса POST /v1/logs
+type ExportLogsServiceRequest struct {
+	ResourceLogs []ResourceLogs `json:"resourceLogs"`
+}
+
+// ResourceLogs записи одного ресурса (сервиса)
+type ResourceLogs struct {
+	Resource  Resource    `json:"resource"`
+	ScopeLogs []ScopeLogs `json:"scopeLogs"`
+}
+
+// Resource атрибуты ресурса, например service.name
+type Resource struct {
+	Attributes []KeyValue `json:"attributes,omitempty"`
+}
+
+// ScopeLogs записи одной библиотеки (instrumentation scope)
+type ScopeLogs struct {
+	Scope      Scope       `json:"scope"`
+	LogRecords []LogRecord `json:"logRecords"`
+}
+
+// Scope описывает источник записей
+type Scope struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// LogRecord одна запись лога OTLP
+type LogRecord struct {
+	TimeUnixNano         string     `json:"timeUnixNano,omitempty"`
+	ObservedTimeUnixNano string     `json:"observedTimeUnixNano,omitempty"`
+	SeverityNumber       int        `json:"severityNumber,omitempty"`
+	SeverityText         string     `json:"severityText,omitempty"`
+	Body                 *AnyValue  `json:"body,omitempty"`
+	Attributes           []KeyValue `json:"attributes,omitempty"`
+	TraceID              string     `json:"traceId,omitempty"`
+	SpanID               string     `json:"spanId,omitempty"`
+}
+
+// KeyValue атрибут записи или ресурса
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue значение атрибута. Заполнено ровно одно поле.
+// int64 по правилам proto3 JSON передается строкой.
+type AnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// String возвращает значение в текстовом виде, удобно для проверок в тестах
+func (v AnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	}
+	return ""
+}
+
+// Уровни серьезности OTLP (SeverityNumber), см. спецификацию Logs Data Model
+const (
+	SeverityDebug = 5
+	SeverityInfo  = 9
+	SeverityWarn  = 13
+	SeverityError = 17
+	SeverityFatal = 21
+)
+
+// severityOf переводит значение ключа "level" (как в go-kit/log/level) в номер OTLP
+func severityOf(level string) int {
+	switch strings.ToLower(level) {
+	case "trace", "debug":
+		return SeverityDebug
+	case "info":
+		return SeverityInfo
+	case "warn", "warning":
+		return SeverityWarn
+	case "error":
+		return SeverityError
+	case "fatal", "crit", "critical", "panic":
+		return SeverityFatal
+	}
+	return 0
+}
+
+// toAnyValue приводит произвольное значение из keyvals к AnyValue
+func toAnyValue(v interface{}) AnyValue {
+	switch x := v.(type) {
+	case nil:
+		return stringValue("null")
+	case string:
+		return stringValue(x)
+	case bool:
+		return AnyValue{BoolValue: &x}
+	case int:
+		return intValue(int64(x))
+	case int8:
+		return intValue(int64(x))
+	case int16:
+		return intValue(int64(x))
+	case int32:
+		return intValue(int64(x))
+	case int64:
+		return intValue(x)
+	case uint8:
+		return intValue(int64(x))
+	case uint16:
+		return intValue(int64(x))
+	case uint32:
+		return intValue(int64(x))
+	case float32:
+		f := float64(x)
+		return AnyValue{DoubleValue: &f}
+	case float64:
+		return AnyValue{DoubleValue: &x}
+	case time.Time:
+		return stringValue(x.Format(time.RFC3339Nano))
+	case error:
+		return stringValue(x.Error())
+	case fmt.Stringer:
+		return stringValue(x.String())
+	}
+	return stringValue(fmt.Sprint(v))
+}
+
+func stringValue(s string) AnyValue {
+	return AnyValue{StringValue: &s}
+}
+
+func intValue(i int64) AnyValue {
+	s := strconv.FormatInt(i, 10)
+	return AnyValue{IntValue: &s}
+}
+
+// keyString приводит ключ к строке так же, как это делает logfmt-логгер
+func keyString(k interface{}) string {
+	switch x := k.(type) {
+	case string:
+		return x
+	case fmt.Stringer:
+		return x.String()
+	}
+	return fmt.Sprint(k)
+}
+
+// isHexID проверяет, что строка похожа на trace/span id заданной длины в байтах
+func isHexID(s string, size int) bool {
+	if len(s) != size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// keyvalsToAttributes переводит пары ключ/значение в атрибуты OTLP
+func keyvalsToAttributes(keyvals []interface{}) []KeyValue {
+	attrs := make([]KeyValue, 0, len(keyvals)/2)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		attrs = append(attrs, KeyValue{Key: keyString(keyvals[i]), Value: toAnyValue(keyvals[i+1])})
+	}
+	return attrs
+}
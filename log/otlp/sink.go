@@ -0,0 +1,316 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/r3code/go-useful-snippets/log"
+)
+
+// Ключи, которые Sink извлекает из записи в поля OTLP log record
+const (
+	LevelKey   = "level"
+	MessageKey = "msg"
+	TimeKey    = "time"
+	TraceIDKey = "trace_id"
+	SpanIDKey  = "span_id"
+)
+
+const (
+	defaultBatchSize     = 512
+	defaultBufferSize    = 4096
+	defaultFlushInterval = time.Second
+	defaultScopeName     = "github.com/r3code/go-useful-snippets/log"
+)
+
+// ErrBufferFull возвращается из `Log`, когда буфер неотправленных записей заполнен и запись отброшена
+var ErrBufferFull = errors.New("otlp: log buffer is full, record dropped")
+
+// ErrClosed возвращается из `Log` после `Close`, запись отброшена
+var ErrClosed = errors.New("otlp: sink is closed, record dropped")
+
+// Config настройки Sink
+type Config struct {
+	// Endpoint полный адрес приемника, например "http://localhost:4318/v1/logs"
+	Endpoint string
+	// Headers дополнительные заголовки запроса (авторизация и т.п.)
+	Headers map[string]string
+	// Resource атрибуты ресурса парами ключ/значение, например "service.name", "billing"
+	Resource []interface{}
+	// ScopeName имя instrumentation scope, по умолчанию путь пакета log
+	ScopeName string
+	// Client HTTP-клиент, по умолчанию клиент с таймаутом 10 секунд
+	Client *http.Client
+	// BatchSize число записей, при накоплении которого отправка начинается не дожидаясь FlushInterval
+	BatchSize int
+	// BufferSize предельное число неотправленных записей, сверх него записи отбрасываются
+	BufferSize int
+	// FlushInterval период отправки накопленных записей
+	FlushInterval time.Duration
+	// OnError вызывается с ошибкой каждой неудачной фоновой отправки
+	OnError func(err error)
+}
+
+// Sink буферизует записи лога и пачками отправляет их в OTLP/HTTP приемник.
+// Реализует интерфейс `log.Logger`, безопасен для конкурентного использования.
+type Sink struct {
+	cfg      Config
+	resource Resource
+	now      func() time.Time
+
+	mu      sync.Mutex
+	records []LogRecord
+	lastErr error
+	closed  bool
+
+	sendMu sync.Mutex
+	wakeup chan struct{}
+	done   chan struct{}
+	exited chan struct{}
+	once   sync.Once
+}
+
+// NewSink создает Sink и запускает фоновую отправку записей.
+// После использования необходимо вызвать `Close`, чтобы отправить остаток буфера.
+func NewSink(cfg Config) *Sink {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.BufferSize < cfg.BatchSize {
+		cfg.BufferSize = defaultBufferSize
+		if cfg.BufferSize < cfg.BatchSize {
+			cfg.BufferSize = cfg.BatchSize
+		}
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+	if cfg.ScopeName == "" {
+		cfg.ScopeName = defaultScopeName
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	s := &Sink{
+		cfg:      cfg,
+		resource: Resource{Attributes: keyvalsToAttributes(cfg.Resource)},
+		now:      time.Now,
+		wakeup:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+	go s.loop()
+	return s
+}
+
+// Log преобразует пары ключ/значение в OTLP log record и ставит его в очередь на отправку.
+// Возвращает ErrBufferFull, если запись отброшена, и ErrClosed после `Close`. Ошибки фоновой отправки через Log не
+// возвращаются, чтобы недоступность приемника не ломала логирование, их сообщают
+// `Config.OnError` и `LastError`.
+func (s *Sink) Log(keyvals ...interface{}) error {
+	rec := s.convert(keyvals)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	if len(s.records) >= s.cfg.BufferSize {
+		s.mu.Unlock()
+		return ErrBufferFull
+	}
+	s.records = append(s.records, rec)
+	full := len(s.records) >= s.cfg.BatchSize
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.wakeup <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// LastError возвращает ошибку последней фоновой отправки или nil, если она прошла успешно
+func (s *Sink) LastError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// Flush немедленно отправляет все накопленные записи.
+// Пачка, которую не удалось отправить, отбрасывается, ошибка возвращается вызывающему.
+func (s *Sink) Flush(ctx context.Context) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	for {
+		s.mu.Lock()
+		n := len(s.records)
+		if n > s.cfg.BatchSize {
+			n = s.cfg.BatchSize
+		}
+		batch := s.records[:n:n]
+		s.records = s.records[n:]
+		s.mu.Unlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.export(ctx, batch); err != nil {
+			return err
+		}
+	}
+}
+
+// Close останавливает фоновую отправку и отправляет остаток буфера.
+// Записи, переданные в `Log` после Close, отбрасываются.
+func (s *Sink) Close(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.once.Do(func() { close(s.done) })
+	<-s.exited
+	return s.Flush(ctx)
+}
+
+func (s *Sink) loop() {
+	defer close(s.exited)
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		case <-s.wakeup:
+		}
+		err := s.Flush(context.Background())
+		s.mu.Lock()
+		s.lastErr = err
+		s.mu.Unlock()
+		if err != nil && s.cfg.OnError != nil {
+			s.cfg.OnError(err)
+		}
+	}
+}
+
+func (s *Sink) convert(keyvals []interface{}) LogRecord {
+	if len(keyvals)%2 != 0 {
+		keyvals = append(keyvals, "(MISSING)")
+	}
+	rec := LogRecord{ObservedTimeUnixNano: unixNano(s.now())}
+	attrs := make([]interface{}, 0, len(keyvals))
+	for i := 0; i < len(keyvals); i += 2 {
+		key, val := keyString(keyvals[i]), keyvals[i+1]
+		switch key {
+		case LevelKey:
+			text := fmt.Sprint(val)
+			if sev := severityOf(text); sev != 0 {
+				rec.SeverityText = text
+				rec.SeverityNumber = sev
+				continue
+			}
+		case MessageKey:
+			if rec.Body == nil {
+				body := toAnyValue(val)
+				rec.Body = &body
+				continue
+			}
+		case TimeKey:
+			if t, ok := eventTime(val); ok {
+				rec.TimeUnixNano = unixNano(t)
+				continue
+			}
+		case TraceIDKey:
+			if id := fmt.Sprint(val); isHexID(id, 16) {
+				rec.TraceID = id
+				continue
+			}
+		case SpanIDKey:
+			if id := fmt.Sprint(val); isHexID(id, 8) {
+				rec.SpanID = id
+				continue
+			}
+		}
+		attrs = append(attrs, key, val)
+	}
+	rec.Attributes = keyvalsToAttributes(attrs)
+	return rec
+}
+
+func (s *Sink) export(ctx context.Context, batch []LogRecord) error {
+	body, err := json.Marshal(ExportLogsServiceRequest{
+		ResourceLogs: []ResourceLogs{{
+			Resource: s.resource,
+			ScopeLogs: []ScopeLogs{{
+				Scope:      Scope{Name: s.cfg.ScopeName},
+				LogRecords: batch,
+			}},
+		}},
+	})
+	if err != nil {
+		return errors.Annotate(err, "otlp: marshal export request")
+	}
+	req, err := http.NewRequest(http.MethodPost, s.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Annotate(err, "otlp: create export request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return errors.Annotatef(err, "otlp: export %d records", len(batch))
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("otlp: export %d records: collector responded %s", len(batch), resp.Status)
+	}
+	return nil
+}
+
+// eventTime распознает время события: time.Time, а также строку или
+// fmt.Stringer в формате RFC3339, как у `log.DefaultTimestampUTC`
+func eventTime(val interface{}) (time.Time, bool) {
+	var text string
+	switch v := val.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		text = v
+	case fmt.Stringer:
+		text = v.String()
+	default:
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, text)
+	return t, err == nil
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// WithContext добавляет к логгеру идентификаторы трассы и спана OpenTelemetry из контекста.
+// Если в контексте нет активного спана, возвращает логгер без изменений.
+func WithContext(l log.Logger, ctx context.Context) log.Logger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return l
+	}
+	return log.With(l, TraceIDKey, sc.TraceID().String(), SpanIDKey, sc.SpanID().String())
+}
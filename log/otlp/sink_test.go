@@ -0,0 +1,238 @@
+package otlp_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/r3code/go-useful-snippets/log"
+	"github.com/r3code/go-useful-snippets/log/otlp"
+)
+
+// fakeCollector принимает запросы OTLP/HTTP JSON и запоминает полученные записи
+type fakeCollector struct {
+	mu       sync.Mutex
+	requests []otlp.ExportLogsServiceRequest
+	headers  []http.Header
+	status   int
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req otlp.ExportLogsServiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	c.requests = append(c.requests, req)
+	c.headers = append(c.headers, r.Header)
+	status := c.status
+	c.mu.Unlock()
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+}
+
+func (c *fakeCollector) records() []otlp.LogRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []otlp.LogRecord
+	for _, req := range c.requests {
+		for _, rl := range req.ResourceLogs {
+			for _, sl := range rl.ScopeLogs {
+				out = append(out, sl.LogRecords...)
+			}
+		}
+	}
+	return out
+}
+
+func attr(rec otlp.LogRecord, key string) (string, bool) {
+	for _, kv := range rec.Attributes {
+		if kv.Key == key {
+			return kv.Value.String(), true
+		}
+	}
+	return "", false
+}
+
+func Test_Sink_ExportsRecord(t *testing.T) {
+	collector := &fakeCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	sink := otlp.NewSink(otlp.Config{
+		Endpoint: srv.URL + "/v1/logs",
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+		Resource: []interface{}{"service.name", "billing"},
+	})
+	traceID := trace.TraceID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10}
+	spanID := trace.SpanID{0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11}
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+	ts := time.Date(2014, 11, 12, 11, 45, 26, 0, time.UTC)
+
+	logger := otlp.WithContext(log.With(sink, "component", "payments"), ctx)
+	if err := logger.Log("time", ts, "level", "error", "msg", "payment failed", "amount", 42, "retry", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := collector.records()
+	if len(recs) != 1 {
+		t.Fatalf("want 1 record, have %d", len(recs))
+	}
+	rec := recs[0]
+	if rec.SeverityNumber != otlp.SeverityError || rec.SeverityText != "error" {
+		t.Errorf("wrong severity: %d %q", rec.SeverityNumber, rec.SeverityText)
+	}
+	if rec.Body == nil || rec.Body.String() != "payment failed" {
+		t.Errorf("wrong body: %v", rec.Body)
+	}
+	if rec.TimeUnixNano != "1415792726000000000" {
+		t.Errorf("wrong time: %s", rec.TimeUnixNano)
+	}
+	if rec.TraceID != traceID.String() || rec.SpanID != spanID.String() {
+		t.Errorf("wrong trace context: %s/%s", rec.TraceID, rec.SpanID)
+	}
+	for key, want := range map[string]string{"component": "payments", "amount": "42", "retry": "true"} {
+		if have, ok := attr(rec, key); !ok || have != want {
+			t.Errorf("attribute %s: want %q, have %q", key, want, have)
+		}
+	}
+	if _, ok := attr(rec, "trace_id"); ok {
+		t.Errorf("trace_id must not be duplicated in attributes")
+	}
+
+	res := collector.requests[0].ResourceLogs[0].Resource
+	if len(res.Attributes) != 1 || res.Attributes[0].Key != "service.name" || res.Attributes[0].Value.String() != "billing" {
+		t.Errorf("wrong resource attributes: %+v", res.Attributes)
+	}
+	if h := collector.headers[0].Get("Authorization"); h != "Bearer token" {
+		t.Errorf("header not sent: %q", h)
+	}
+}
+
+func Test_Sink_TimeFromTimestampValuer(t *testing.T) {
+	collector := &fakeCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	sink := otlp.NewSink(otlp.Config{Endpoint: srv.URL, FlushInterval: time.Hour})
+	before := time.Now()
+	_ = log.With(sink, "time", log.DefaultTimestampUTC).Log("msg", "valuer")
+	_ = sink.Log("time", "2014-11-12T11:45:26.5Z", "msg", "string")
+	if err := sink.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := collector.records()
+	if len(recs) != 2 {
+		t.Fatalf("want 2 records, have %d", len(recs))
+	}
+	for _, rec := range recs {
+		if _, ok := attr(rec, "time"); ok {
+			t.Errorf("%v: time must not be an attribute", rec.Body)
+		}
+	}
+	ns, err := strconv.ParseInt(recs[0].TimeUnixNano, 10, 64)
+	if err != nil || time.Unix(0, ns).Before(before.Add(-time.Second)) {
+		t.Errorf("wrong valuer time: %q", recs[0].TimeUnixNano)
+	}
+	if recs[1].TimeUnixNano != "1415792726500000000" {
+		t.Errorf("wrong string time: %s", recs[1].TimeUnixNano)
+	}
+}
+
+func Test_Sink_BatchSizeTriggersExport(t *testing.T) {
+	collector := &fakeCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	sink := otlp.NewSink(otlp.Config{Endpoint: srv.URL, BatchSize: 2, FlushInterval: time.Hour})
+	defer sink.Close(context.Background())
+	_ = sink.Log("msg", "one")
+	_ = sink.Log("msg", "two")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(collector.records()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("batch was not exported before FlushInterval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_Sink_LogAfterClose(t *testing.T) {
+	collector := &fakeCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	sink := otlp.NewSink(otlp.Config{Endpoint: srv.URL, FlushInterval: time.Hour})
+	_ = sink.Log("msg", "before")
+	if err := sink.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Log("msg", "after"); err != otlp.ErrClosed {
+		t.Errorf("Log after Close must return ErrClosed, have %v", err)
+	}
+	if err := sink.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if recs := collector.records(); len(recs) != 1 {
+		t.Errorf("want only the record logged before Close, have %d", len(recs))
+	}
+}
+
+func Test_Sink_ExportErrorReported(t *testing.T) {
+	collector := &fakeCollector{status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	sink := otlp.NewSink(otlp.Config{Endpoint: srv.URL, FlushInterval: time.Hour})
+	_ = sink.Log("msg", "lost")
+	if err := sink.Flush(context.Background()); err == nil {
+		t.Errorf("Flush must return error when collector rejects the request")
+	}
+	_ = sink.Close(context.Background())
+}
+
+func Test_Sink_BackgroundExportErrorNotReturnedFromLog(t *testing.T) {
+	collector := &fakeCollector{status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	errs := make(chan error, 100)
+	sink := otlp.NewSink(otlp.Config{
+		Endpoint:      srv.URL,
+		FlushInterval: 5 * time.Millisecond,
+		OnError:       func(err error) { errs <- err },
+	})
+	defer sink.Close(context.Background())
+
+	for i := 0; i < 20; i++ {
+		if err := sink.Log("msg", "lost"); err != nil {
+			t.Fatalf("Log must not return export errors, have %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-errs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnError was not called")
+	}
+	if sink.LastError() == nil {
+		t.Error("LastError must report the failed export")
+	}
+}
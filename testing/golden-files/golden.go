@@ -1,3 +1,5 @@
+//go:build ignore
+
 package somepacakge_test
 
 import (